@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Lavoaster/cloudsmith-sync/journal"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is an artifact that has been built before.
+type Entry struct {
+	Key        string    `json:"key"`
+	Repository string    `json:"repository"`
+	Ref        string    `json:"ref"`
+	Branch     bool      `json:"branch"`
+	Commit     string    `json:"commit"`
+	Package    string    `json:"package"`
+	Version    string    `json:"version"`
+	Path       string    `json:"path"`
+	Md5        string    `json:"md5,omitempty"`
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+}
+
+type persistedIndex struct {
+	Entries []Entry `json:"entries"`
+}
+
+// change is a logged Put.
+type change struct {
+	Put *Entry `json:"put"`
+}
+
+// Limits bound the artifacts kept by Prune, a zero value means no limit.
+type Limits struct {
+	MaxSize int64
+	MaxAge  time.Duration
+}
+
+// Index keeps track of built artifacts, so a ref is only built again when its
+// commit or the config that affects its artifact changes. New entries are
+// logged to disk as they're added, while entries being used is only recorded
+// by Flush.
+type Index struct {
+	journal *journal.Journal
+	mutex   sync.Mutex
+	entries map[string]Entry
+	// used are the keys of entries used since they were last logged.
+	used map[string]bool
+}
+
+// Key identifies an artifact by the commit it was built from and everything
+// else that ends up in it, like the version and archive format.
+func Key(commit string, config ...string) string {
+	hash := sha256.Sum256([]byte(commit + "\x00" + strings.Join(config, "\x00")))
+
+	return hex.EncodeToString(hash[:])
+}
+
+// Open loads the index persisted at path, starting an empty one when it
+// doesn't exist yet.
+func Open(path string) (*Index, error) {
+	index := &Index{
+		entries: make(map[string]Entry),
+		used:    make(map[string]bool),
+	}
+
+	var persisted persistedIndex
+
+	j, err := journal.Open(path, &persisted)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range persisted.Entries {
+		index.entries[entry.Key] = entry
+	}
+
+	err = j.Replay(func(raw json.RawMessage) error {
+		var logged change
+
+		if err := json.Unmarshal(raw, &logged); err != nil {
+			return err
+		}
+
+		if logged.Put != nil {
+			index.entries[logged.Put.Key] = *logged.Put
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	index.journal = j
+
+	return index, nil
+}
+
+// Get returns the entry for a key when its artifact still exists, marking it
+// as used. Nothing is written to disk until Flush.
+func (i *Index) Get(key string) (Entry, bool) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	entry, ok := i.entries[key]
+
+	if !ok {
+		return Entry{}, false
+	}
+
+	// The entry is left out of the next snapshot, or replaced when the
+	// artifact is built again
+	if _, err := os.Stat(entry.Path); err != nil {
+		delete(i.entries, key)
+		delete(i.used, key)
+
+		return Entry{}, false
+	}
+
+	entry.LastUsedAt = time.Now()
+	i.entries[key] = entry
+	i.used[key] = true
+
+	return entry, true
+}
+
+// Put adds or replaces an entry, filling in its size and times when they're
+// not set.
+func (i *Index) Put(entry Entry) error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if entry.Size == 0 {
+		info, err := os.Stat(entry.Path)
+
+		if err != nil {
+			return err
+		}
+
+		entry.Size = info.Size()
+	}
+
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	if entry.LastUsedAt.IsZero() {
+		entry.LastUsedAt = entry.CreatedAt
+	}
+
+	i.entries[entry.Key] = entry
+	i.used[entry.Key] = true
+
+	return i.flush()
+}
+
+// Flush records which entries have been used since the last time it was
+// called, so Prune removes the least recently used ones across restarts.
+func (i *Index) Flush() error {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.flush()
+}
+
+// Entries returns every entry, least recently used first.
+func (i *Index) Entries() []Entry {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	return i.sortedEntries()
+}
+
+// Contains reports whether a file is an artifact tracked by the index.
+func (i *Index) Contains(path string) bool {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for _, entry := range i.entries {
+		if filepath.Clean(entry.Path) == filepath.Clean(path) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Prune removes the artifacts of entries that keep rejects, that haven't been
+// used within MaxAge and then the least recently used ones until the rest fit
+// in MaxSize. The removed entries are returned, with dryRun nothing is
+// actually removed.
+func (i *Index) Prune(keep func(Entry) bool, limits Limits, dryRun bool) ([]Entry, error) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	var removed []Entry
+	var kept []Entry
+	var totalSize int64
+
+	for _, entry := range i.sortedEntries() {
+		if !keep(entry) || (limits.MaxAge > 0 && time.Since(entry.LastUsedAt) > limits.MaxAge) {
+			removed = append(removed, entry)
+			continue
+		}
+
+		kept = append(kept, entry)
+		totalSize += entry.Size
+	}
+
+	for len(kept) > 0 && limits.MaxSize > 0 && totalSize > limits.MaxSize {
+		removed = append(removed, kept[0])
+		totalSize -= kept[0].Size
+		kept = kept[1:]
+	}
+
+	if dryRun {
+		return removed, nil
+	}
+
+	for _, entry := range removed {
+		if err := os.Remove(entry.Path); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+
+		// Artifacts live in a directory of their own, which is left alone if
+		// anything else ended up in it
+		os.Remove(filepath.Dir(entry.Path))
+
+		delete(i.entries, entry.Key)
+		delete(i.used, entry.Key)
+	}
+
+	return removed, i.journal.Compact(persistedIndex{Entries: i.sortedEntries()})
+}
+
+func (i *Index) sortedEntries() []Entry {
+	entries := make([]Entry, 0, len(i.entries))
+
+	for _, entry := range i.entries {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].LastUsedAt.Before(entries[b].LastUsedAt)
+	})
+
+	return entries
+}
+
+// flush logs the entries that have been added or used, compacting the log
+// into a new snapshot once it's grown long enough. The caller must hold the
+// mutex.
+func (i *Index) flush() error {
+	if len(i.used) == 0 {
+		return nil
+	}
+
+	var changes []interface{}
+
+	for key := range i.used {
+		entry := i.entries[key]
+		changes = append(changes, change{Put: &entry})
+	}
+
+	if err := i.journal.Append(changes...); err != nil {
+		return err
+	}
+
+	i.used = make(map[string]bool)
+
+	if !i.journal.NeedsCompacting(len(i.entries)) {
+		return nil
+	}
+
+	return i.journal.Compact(persistedIndex{Entries: i.sortedEntries()})
+}
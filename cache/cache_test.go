@@ -0,0 +1,211 @@
+package cache_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/cache"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newArtifact(t *testing.T, dir, key string, size int) string {
+	path := filepath.Join(dir, key, "org-package.zip")
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestKey(t *testing.T) {
+	key := cache.Key("abc123", "1.0.0", "zip")
+
+	if key != cache.Key("abc123", "1.0.0", "zip") {
+		t.Errorf("[!] Key() isn't stable")
+	}
+
+	if key == cache.Key("abc123", "1.0.0", "tar") || key == cache.Key("abc124", "1.0.0", "zip") {
+		t.Errorf("[!] Key() ignores part of what identifies an artifact")
+	}
+}
+
+func TestIndexPersistsEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-cache")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	index, err := cache.Open(dir + "/index.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := index.Get("missing"); ok {
+		t.Errorf("[!] Get() found an entry in an empty index")
+	}
+
+	path := newArtifact(t, dir, "a", 10)
+
+	if err := index.Put(cache.Entry{Key: "a", Package: "org/package", Version: "1.0.0", Path: path}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := cache.Open(dir + "/index.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok := reopened.Get("a")
+
+	if !ok || entry.Size != 10 || entry.Version != "1.0.0" || entry.CreatedAt.IsZero() {
+		t.Fatalf("[!] Get(a) = %+v, %v; want the persisted entry", entry, ok)
+	}
+
+	os.Remove(path)
+
+	if _, ok := reopened.Get("a"); ok {
+		t.Errorf("[!] Get() returned an entry whose artifact was removed")
+	}
+
+	if len(reopened.Entries()) != 0 {
+		t.Errorf("[!] the entry of a removed artifact was kept")
+	}
+}
+
+func TestIndexFlushRecordsUse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-cache")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	index, err := cache.Open(dir + "/index.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lastUsedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	err = index.Put(cache.Entry{Key: "a", Path: newArtifact(t, dir, "a", 10), LastUsedAt: lastUsedAt})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index.Get("a")
+
+	reopened, err := cache.Open(dir + "/index.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := reopened.Entries()[0]; !entry.LastUsedAt.Equal(lastUsedAt) {
+		t.Errorf("[!] using an entry was written before Flush()")
+	}
+
+	if err := index.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err = cache.Open(dir + "/index.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if entry := reopened.Entries()[0]; !entry.LastUsedAt.After(lastUsedAt) {
+		t.Errorf("[!] Flush() didn't record the entry being used")
+	}
+}
+
+func TestIndexPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-cache")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	index, err := cache.Open(dir + "/index.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	entries := []cache.Entry{
+		{Key: "unconfigured", Repository: "gone", LastUsedAt: now},
+		{Key: "stale", Repository: "kept", LastUsedAt: now.Add(-48 * time.Hour)},
+		{Key: "oldest", Repository: "kept", LastUsedAt: now.Add(-3 * time.Hour)},
+		{Key: "older", Repository: "kept", LastUsedAt: now.Add(-2 * time.Hour)},
+		{Key: "newest", Repository: "kept", LastUsedAt: now.Add(-time.Hour)},
+	}
+
+	for _, entry := range entries {
+		entry.Path = newArtifact(t, dir, entry.Key, 100)
+
+		if err := index.Put(entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	keep := func(entry cache.Entry) bool {
+		return entry.Repository == "kept"
+	}
+
+	limits := cache.Limits{MaxSize: 200, MaxAge: 24 * time.Hour}
+
+	removed, err := index.Prune(keep, limits, true)
+
+	if err != nil || len(removed) != 3 || len(index.Entries()) != 5 {
+		t.Fatalf("[!] dry run Prune() removed %d of %d entries, %v; want 3 of 5 listed and none removed", len(removed), len(index.Entries()), err)
+	}
+
+	removed, err = index.Prune(keep, limits, false)
+
+	if err != nil {
+		t.Fatalf("[!] Prune() returned %v", err)
+	}
+
+	var removedKeys []string
+
+	for _, entry := range removed {
+		removedKeys = append(removedKeys, entry.Key)
+
+		if _, err := os.Stat(filepath.Join(dir, entry.Key)); !os.IsNotExist(err) {
+			t.Errorf("[!] the artifact of %s wasn't removed", entry.Key)
+		}
+	}
+
+	expected := map[string]bool{"unconfigured": true, "stale": true, "oldest": true}
+
+	if len(removedKeys) != len(expected) {
+		t.Errorf("[!] Prune() removed %v; want unconfigured, stale and oldest", removedKeys)
+	}
+
+	for _, key := range removedKeys {
+		if !expected[key] {
+			t.Errorf("[!] Prune() removed %v; want unconfigured, stale and oldest", removedKeys)
+		}
+	}
+
+	for _, key := range []string{"older", "newest"} {
+		if _, ok := index.Get(key); !ok {
+			t.Errorf("[!] Prune() removed %s", key)
+		}
+	}
+}
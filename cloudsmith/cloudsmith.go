@@ -1,36 +1,57 @@
 package cloudsmith
 
 import (
-	"bytes"
 	"crypto/md5"
 	"encoding/hex"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
 	"io"
-	"log"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"os"
 	"path/filepath"
-	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-type Error struct {
-	Detail string `json:"detail"`
-}
-
 type Client struct {
-	Files         cloudsmith_api.FilesApi
-	Packages      cloudsmith_api.PackagesApi
-	KnownVersions []string
+	Files    cloudsmith_api.FilesApi
+	Packages cloudsmith_api.PackagesApi
+	// KnownVersions maps the name:version of loaded packages to their file
+	// name.
+	KnownVersions map[string]string
+	// MaxRetries is how many times a request that hit the rate limit or a
+	// server error is retried.
+	MaxRetries int
+	// RetryBackoff is how long to wait before the first retry, it doubles
+	// with every attempt.
+	RetryBackoff time.Duration
+	// Progress, when set, is called as artifacts are uploaded, each time
+	// another tenth of the file has been sent.
+	Progress func(filename string, sent, total int64)
+
+	loaded         bool
+	rateLimitMutex sync.Mutex
+	rateLimitReset time.Time
 }
 
 func NewClient(apiKey string) *Client {
+	return NewClientWithBasePath(apiKey, "")
+}
+
+// NewClientWithBasePath creates a client for a different API location, like
+// the stand-in server from cloudsmithtest. An empty base path uses the
+// default.
+func NewClientWithBasePath(apiKey, basePath string) *Client {
 	configuration := cloudsmith_api.NewConfiguration()
 	configuration.AddDefaultHeader("X-Api-Key", apiKey)
 
+	if basePath != "" {
+		configuration.BasePath = basePath
+	}
+
 	return &Client{
 		Files: cloudsmith_api.FilesApi{
 			Configuration: configuration,
@@ -38,201 +59,282 @@ func NewClient(apiKey string) *Client {
 		Packages: cloudsmith_api.PackagesApi{
 			Configuration: configuration,
 		},
+		KnownVersions: make(map[string]string),
+		MaxRetries:    defaultMaxRetries,
+		RetryBackoff:  defaultRetryBackoff,
 	}
 }
 
-func (c *Client) UploadComposerPackage(owner, repo, artifactPath string) (csPkg *cloudsmith_api.ModelPackage, error error) {
-	fileName := filepath.Base(artifactPath)
+// UploadComposerPackage uploads an artifact as a new composer package. With
+// republish an existing package of the same version is replaced once the new
+// one has been processed, rather than the upload being rejected.
+func (c *Client) UploadComposerPackage(owner, repo string, artifact Artifact, republish bool) (*cloudsmith_api.ModelPackage, error) {
+	var csPkg *cloudsmith_api.ModelPackage
+
+	fileName := filepath.Base(artifact.Path)
+	checksum := artifact.Md5
+
+	if checksum == "" {
+		var err error
+
+		checksum, err = calculateMd5Checksum(artifact.Path)
+
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Get upload details from Cloudsmith (which is a pre-signed s3 upload)
-	upload, rawUpload, err := c.Files.FilesCreate(owner, repo, cloudsmith_api.FilesCreate{
-		Filename:    fileName,
-		Md5Checksum: calculateMd5Checksum(artifactPath),
+	var upload *cloudsmith_api.PackageFileUpload
+
+	err := c.create(func() (rawUpload *cloudsmith_api.APIResponse, err error) {
+		upload, rawUpload, err = c.Files.FilesCreate(owner, repo, cloudsmith_api.FilesCreate{
+			Filename:    fileName,
+			Md5Checksum: checksum,
+		})
+
+		return
 	})
 
-	if err := checkForCloudsmithRequestError(rawUpload, err); err != nil {
-		return csPkg, err
+	if err != nil {
+		return nil, err
 	}
 
 	// Convert the upload interface{} to map[string]string
 	params := getParams(upload.UploadFields)
 
 	// Prepare request to upload to S3 based on data given from Cloudsmith
-	req, err := newS3UploadRequest(upload.UploadUrl, params, "file", artifactPath)
+	req, err := newS3UploadRequest(upload.UploadUrl, params, "file", artifact.Path, c.progress(fileName))
 
 	if err != nil {
-		return csPkg, err
+		return nil, err
 	}
 
 	// Perform the upload
 	resp, err := http.DefaultClient.Do(req)
 
 	if err != nil {
-		return csPkg, err
+		return nil, err
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode >= 300 {
-		return csPkg, errors.New("s3 file upload failed")
+		return nil, &Error{StatusCode: resp.StatusCode, Detail: "s3 file upload failed"}
 	}
 
 	// Alright, the file uploaded, now to create a package on Cloudsmith and
 	// link it to the file
-	pkg, rawPkg, err := c.Packages.PackagesUploadComposer(owner, repo, cloudsmith_api.PackagesUploadComposer{
-		PackageFile: upload.Identifier,
+	err = c.create(func() (rawPkg *cloudsmith_api.APIResponse, err error) {
+		csPkg, rawPkg, err = c.Packages.PackagesUploadComposer(owner, repo, cloudsmith_api.PackagesUploadComposer{
+			PackageFile: upload.Identifier,
+			Republish:   republish,
+		})
+
+		return
 	})
 
-	if err := checkForCloudsmithRequestError(rawPkg, err); err != nil {
-		return csPkg, err
+	if err != nil {
+		return nil, err
 	}
 
-	return pkg, nil
+	return csPkg, nil
 }
 
-func (c *Client) LoadPackages(owner, repo string) error {
-	pageSize := 100
-	page := 1
+// progress returns the callback for an uploads progress, which passes it on to
+// Progress each time another tenth of the file has been sent.
+func (c *Client) progress(filename string) func(sent, total int64) {
+	if c.Progress == nil {
+		return nil
+	}
 
-	for {
-		pkgs, rawList, err := c.Packages.PackagesList(owner, repo, int32(page), int32(pageSize), "status:completed format:composer")
+	reported := int64(-1)
 
-		if err := checkForCloudsmithRequestError(rawList, err); err != nil {
-			// If the error is because of a 404, we've reached the end of the list!
-			if rawList.StatusCode == 404 {
-				break
-			}
+	return func(sent, total int64) {
+		tenths := int64(10)
 
-			return err
+		if total > 0 {
+			tenths = sent * 10 / total
 		}
 
-		for _, pkg := range pkgs {
-			c.KnownVersions = append(c.KnownVersions, pkg.Name+":"+pkg.Version)
-		}
+		if tenths > reported {
+			reported = tenths
 
-		if len(pkgs) < pageSize {
-			break
+			c.Progress(filename, sent, total)
 		}
+	}
+}
 
-		page++
+func (c *Client) LoadPackages(owner, repo string) error {
+	pkgs, err := c.ListPackages(owner, repo, "status:completed format:composer")
+
+	if err != nil {
+		return err
 	}
 
+	for _, pkg := range pkgs {
+		c.KnownVersions[pkg.Name+":"+pkg.Version] = pkg.Filename
+	}
+
+	c.loaded = true
+
 	return nil
 }
 
-func (c *Client) RemoteCheckPackageExists(owner, repo, name, version string) (bool, error) {
-	searchTerm := fmt.Sprintf("name:%s version:%s format:composer", name, version)
+// PackageExists checks the packages loaded by LoadPackages, or asks Cloudsmith
+// directly when they haven't been loaded.
+func (c *Client) PackageExists(owner, repo, name, version string) (bool, error) {
+	if c.loaded {
+		return c.IsAwareOfPackage(name, version), nil
+	}
 
-	pkgs, rawList, err := c.Packages.PackagesList(owner, repo, 1, 1, searchTerm)
+	return c.RemoteCheckPackageExists(owner, repo, name, version)
+}
 
-	if err := checkForCloudsmithRequestError(rawList, err); err != nil {
-		// If the error is because of a 404, we've reached the end of the list! or there is nothing to deal with
-		if rawList.StatusCode == 404 {
-			return false, nil
-		}
+// PackageFilename returns the file name of a published package version, or an
+// empty string when it doesn't exist.
+func (c *Client) PackageFilename(owner, repo, name, version string) (string, error) {
+	if c.loaded {
+		return c.KnownVersions[name+":"+version], nil
+	}
 
-		return false, err
+	pkg, err := c.findPackage(owner, repo, fmt.Sprintf("name:%s version:%s format:composer", name, version))
+
+	if err != nil || pkg == nil {
+		return "", err
 	}
 
-	return len(pkgs) != 0, nil
+	return pkg.Filename, nil
 }
 
-func (c *Client) DeletePackageIfExists(owner, repo, name, version string) error {
-	searchTerm := fmt.Sprintf("name:%s version:%s status:completed format:composer", name, version)
+func (c *Client) RemoteCheckPackageExists(owner, repo, name, version string) (bool, error) {
+	pkg, err := c.findPackage(owner, repo, fmt.Sprintf("name:%s version:%s format:composer", name, version))
 
-	pkgs, rawList, err := c.Packages.PackagesList(owner, repo, 1, 1, searchTerm)
+	return pkg != nil, err
+}
 
-	if err := checkForCloudsmithRequestError(rawList, err); err != nil {
-		// If the error is because of a 404, we've reached the end of the list! or there is nothing to deal with
-		if rawList.StatusCode == 404 {
-			return nil
-		}
+func (c *Client) DeletePackageIfExists(owner, repo, name, version string) error {
+	pkg, err := c.findPackage(owner, repo, fmt.Sprintf("name:%s version:%s status:completed format:composer", name, version))
 
+	if err != nil || pkg == nil {
 		return err
 	}
 
-	if len(pkgs) == 0 {
-		return nil
-	}
+	return c.DeletePackage(owner, repo, pkg.SlugPerm)
+}
 
-	// Delete the first matching version
-	pkg := pkgs[0]
+func (c *Client) DeletePackage(owner, repo, identifier string) error {
+	return c.call(func() (*cloudsmith_api.APIResponse, error) {
+		return c.Packages.PackagesDelete(owner, repo, identifier)
+	})
+}
 
-	c.Packages.PackagesDelete(owner, repo, strconv.Itoa(int(pkg.Identifier)))
+// ListPackages pages through every package matching the query.
+func (c *Client) ListPackages(owner, repo, query string) ([]cloudsmith_api.ModelPackage, error) {
+	var packages []cloudsmith_api.ModelPackage
 
-	return nil
-}
+	pageSize := 100
+	page := 1
+
+	for {
+		var pkgs []cloudsmith_api.ModelPackage
+
+		err := c.call(func() (rawList *cloudsmith_api.APIResponse, err error) {
+			pkgs, rawList, err = c.Packages.PackagesList(owner, repo, int32(page), int32(pageSize), query)
+
+			return
+		})
 
-func (c *Client) RetryFailed(owner, repo string) error {
-	pkgs, rawList, err := c.Packages.PackagesList(owner, repo, 1, 100, "status:failed format:composer")
+		if err != nil {
+			// If the error is because of a 404, we've reached the end of the list!
+			if IsNotFound(err) {
+				break
+			}
 
-	if err := checkForCloudsmithRequestError(rawList, err); err != nil {
-		// If the error is because of a 404, we've reached the end of the list! or there is nothing to deal with
-		if rawList.StatusCode != 404 {
-			return nil
+			return nil, err
 		}
-	}
 
-	if len(pkgs) == 0 {
-		return nil
-	}
+		packages = append(packages, pkgs...)
 
-	for _, pkg := range pkgs {
-		c.Packages.PackagesResync(owner, repo, strconv.Itoa(int(pkg.Identifier)))
+		if len(pkgs) < pageSize {
+			break
+		}
+
+		page++
 	}
 
-	return nil
+	return packages, nil
 }
 
-func (c *Client) IsAwareOfPackage(name string, version string) bool {
-	for _, knownVersion := range c.KnownVersions {
-		if knownVersion == name+":"+version {
-			return true
-		}
-	}
+func (c *Client) ResyncPackage(owner, repo, identifier string) error {
+	return c.call(func() (rawPkg *cloudsmith_api.APIResponse, err error) {
+		_, rawPkg, err = c.Packages.PackagesResync(owner, repo, identifier)
 
-	return false
+		return
+	})
 }
 
-func checkForCloudsmithRequestError(response *cloudsmith_api.APIResponse, err error) error {
-	// just straight up return err if it isn't nil
+func (c *Client) PackageStatus(owner, repo, identifier string) (*cloudsmith_api.PackageStatus, error) {
+	var status *cloudsmith_api.PackageStatus
+
+	err := c.call(func() (rawStatus *cloudsmith_api.APIResponse, err error) {
+		status, rawStatus, err = c.Packages.PackagesStatus(owner, repo, identifier)
+
+		return
+	})
+
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Check for 4xx 5xx responses as those *should* hopefully be in the error
-	// format described in their documentation :)
-	if response.StatusCode >= 400 {
-		var cmError Error
+	return status, nil
+}
+
+func (c *Client) IsAwareOfPackage(name string, version string) bool {
+	_, ok := c.KnownVersions[name+":"+version]
 
-		json.Unmarshal(response.Payload, cmError)
+	return ok
+}
 
-		return errors.New(cmError.Detail)
-	}
+// findPackage returns the first package matching the query, or nil when there
+// isn't one.
+func (c *Client) findPackage(owner, repo, query string) (*cloudsmith_api.ModelPackage, error) {
+	var pkgs []cloudsmith_api.ModelPackage
+
+	err := c.call(func() (rawList *cloudsmith_api.APIResponse, err error) {
+		pkgs, rawList, err = c.Packages.PackagesList(owner, repo, 1, 1, query)
 
-	if response.StatusCode >= 300 && response.StatusCode < 400 {
-		return errors.New("api request got a redirect back for some reason")
+		return
+	})
+
+	if err != nil {
+		// If the error is because of a 404, there is nothing to deal with
+		if IsNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, err
 	}
 
-	if response.StatusCode < 200 {
-		return errors.New("request got a status code the 100 range")
+	if len(pkgs) == 0 {
+		return nil, nil
 	}
 
-	// All fine :D
-	return nil
+	return &pkgs[0], nil
 }
 
-func calculateMd5Checksum(filePath string) string {
+func calculateMd5Checksum(filePath string) (string, error) {
 	f, err := os.Open(filePath)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 	defer f.Close()
 
 	h := md5.New()
 	if _, err := io.Copy(h, f); err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
-	return hex.EncodeToString(h.Sum(nil))
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 func getParams(fields interface{}) map[string]string {
@@ -246,32 +348,137 @@ func getParams(fields interface{}) map[string]string {
 	return params
 }
 
-func newS3UploadRequest(uri string, params map[string]string, paramName, path string) (*http.Request, error) {
+// newS3UploadRequest creates the multipart upload of a file, which is streamed
+// from disk as the request is sent rather than held in memory. S3 doesn't take
+// chunked uploads, so the length of the body is worked out up front.
+func newS3UploadRequest(uri string, params map[string]string, paramName, path string, progress func(sent, total int64)) (*http.Request, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-
-	for key, val := range params {
-		_ = writer.WriteField(key, val)
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
 	}
 
-	part, err := writer.CreateFormFile(paramName, filepath.Base(path))
+	partHeader := make(textproto.MIMEHeader)
+	partHeader.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, paramName, filepath.Base(path)))
+	partHeader.Set("Content-Type", artifactContentType(path))
+
+	bodyReader, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+
+	length, err := multipartLength(writer.Boundary(), params, partHeader, info.Size())
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
-	_, err = io.Copy(part, file)
 
-	err = writer.Close()
+	req, err := http.NewRequest("POST", uri, bodyReader)
 	if err != nil {
+		file.Close()
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", uri, body)
+	req.ContentLength = length
 	req.Header.Set("Content-Type", writer.FormDataContentType())
-	return req, err
+
+	var contents io.Reader = file
+
+	if progress != nil {
+		contents = &progressReader{Reader: file, Total: info.Size(), Progress: progress}
+	}
+
+	// The request closes the reading end once it's done with it, even when
+	// sending fails, which stops this from blocking
+	go func() {
+		defer file.Close()
+
+		bodyWriter.CloseWithError(writeMultipart(writer, params, partHeader, contents))
+	}()
+
+	return req, nil
+}
+
+func writeMultipart(writer *multipart.Writer, params map[string]string, partHeader textproto.MIMEHeader, contents io.Reader) error {
+	for key, val := range params {
+		if err := writer.WriteField(key, val); err != nil {
+			return err
+		}
+	}
+
+	part, err := writer.CreatePart(partHeader)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(part, contents); err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+// multipartLength returns the length of a multipart body holding the params
+// and a file of the given size.
+func multipartLength(boundary string, params map[string]string, partHeader textproto.MIMEHeader, size int64) (int64, error) {
+	counter := &countingWriter{}
+	writer := multipart.NewWriter(counter)
+
+	if err := writer.SetBoundary(boundary); err != nil {
+		return 0, err
+	}
+
+	if err := writeMultipart(writer, params, partHeader, strings.NewReader("")); err != nil {
+		return 0, err
+	}
+
+	return counter.Written + size, nil
+}
+
+type countingWriter struct {
+	Written int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.Written += int64(len(p))
+
+	return len(p), nil
+}
+
+// progressReader reports how much of a file has been read.
+type progressReader struct {
+	io.Reader
+	Total    int64
+	Progress func(sent, total int64)
+
+	sent int64
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+
+	r.sent += int64(n)
+
+	if n > 0 || err == io.EOF {
+		r.Progress(r.sent, r.Total)
+	}
+
+	return n, err
+}
+
+// artifactContentType returns the content type of a zip, tar or tar.gz dist.
+func artifactContentType(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".zip"):
+		return "application/zip"
+	case strings.HasSuffix(path, ".tar"):
+		return "application/x-tar"
+	case strings.HasSuffix(path, ".tar.gz"):
+		return "application/gzip"
+	}
+
+	return "application/octet-stream"
 }
@@ -0,0 +1,349 @@
+package cloudsmith_test
+
+import (
+	"archive/zip"
+	"crypto/rand"
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith/cloudsmithtest"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func writeArtifact(t *testing.T, dir, filename, composerJson string) string {
+	return writeArtifactWithPadding(t, dir, filename, composerJson, 0)
+}
+
+// writeArtifactWithPadding writes an artifact with a file of size random bytes
+// next to composer.json, which won't compress.
+func writeArtifactWithPadding(t *testing.T, dir, filename, composerJson string, size int) string {
+	path := filepath.Join(dir, filename)
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+
+	writer, err := archive.Create("composer.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := writer.Write([]byte(composerJson)); err != nil {
+		t.Fatal(err)
+	}
+
+	if size > 0 {
+		writer, err := archive.CreateHeader(&zip.FileHeader{Name: "padding", Method: zip.Store})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.CopyN(writer, rand.Reader, int64(size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestClientUploadsAndReplacesPackages(t *testing.T) {
+	server := cloudsmithtest.NewServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-client")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	client := cloudsmith.NewClientWithBasePath(cloudsmithtest.ApiKey, server.BasePath())
+	composerJson := `{"name": "org/package", "version": "dev-master"}`
+
+	first := writeArtifact(t, dir, "org-package-aaa.zip", composerJson)
+
+	pkg, err := client.UploadComposerPackage("org", "repo", cloudsmith.Artifact{Path: first}, false)
+
+	if err != nil {
+		t.Fatalf("[!] UploadComposerPackage() returned %v", err)
+	}
+
+	if pkg.Name != "org/package" || pkg.Version != "dev-master" {
+		t.Errorf("[!] UploadComposerPackage() = %s@%s; want org/package@dev-master", pkg.Name, pkg.Version)
+	}
+
+	exists, err := client.PackageExists("org", "repo", "org/package", "dev-master")
+
+	if err != nil || !exists {
+		t.Errorf("[!] PackageExists() = %v, %v; want true", exists, err)
+	}
+
+	second := writeArtifact(t, dir, "org-package-bbb.zip", composerJson)
+
+	_, err = client.UploadComposerPackage("org", "repo", cloudsmith.Artifact{Path: second}, false)
+
+	if cmError, ok := err.(*cloudsmith.Error); !ok || cmError.StatusCode != 400 || cmError.Detail != cloudsmithtest.ErrPackageExists.Error() {
+		t.Errorf("[!] uploading an existing version without republishing returned %v; want a 400 with its detail", err)
+	}
+
+	if _, err := client.UploadComposerPackage("org", "repo", cloudsmith.Artifact{Path: second}, true); err != nil {
+		t.Fatalf("[!] republishing returned %v", err)
+	}
+
+	filename, err := client.PackageFilename("org", "repo", "org/package", "dev-master")
+
+	if err != nil || filename != "org-package-bbb.zip" {
+		t.Errorf("[!] PackageFilename() = %q, %v; want the republished file", filename, err)
+	}
+
+	if err := client.DeletePackageIfExists("org", "repo", "org/package", "dev-master"); err != nil {
+		t.Fatalf("[!] DeletePackageIfExists() returned %v", err)
+	}
+
+	exists, err = client.RemoteCheckPackageExists("org", "repo", "org/package", "dev-master")
+
+	if err != nil || exists {
+		t.Errorf("[!] RemoteCheckPackageExists() = %v, %v after deleting; want false", exists, err)
+	}
+}
+
+func TestClientStreamsUploads(t *testing.T) {
+	server := cloudsmithtest.NewServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-client")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := writeArtifactWithPadding(t, dir, "org-package-aaa.zip", `{"name": "org/package", "version": "1.0.0"}`, 8<<20)
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reports []int64
+
+	client := cloudsmith.NewClientWithBasePath(cloudsmithtest.ApiKey, server.BasePath())
+	client.Progress = func(filename string, sent, total int64) {
+		if filename != "org-package-aaa.zip" || total != info.Size() {
+			t.Errorf("[!] progress was reported for %s of %d bytes", filename, total)
+		}
+
+		reports = append(reports, sent)
+	}
+
+	if _, err := client.UploadComposerPackage("org", "repo", cloudsmith.Artifact{Path: path}, false); err != nil {
+		t.Fatalf("[!] UploadComposerPackage() returned %v", err)
+	}
+
+	if len(reports) < 2 || len(reports) > 11 || reports[len(reports)-1] != info.Size() {
+		t.Errorf("[!] progress was reported at %v; want up to every tenth of %d bytes", reports, info.Size())
+	}
+
+	_, err = client.UploadComposerPackage("org", "repo", cloudsmith.Artifact{Path: path, Md5: "d41d8cd98f00b204e9800998ecf8427e"}, true)
+
+	if cmError, ok := err.(*cloudsmith.Error); !ok || cmError.StatusCode != 400 {
+		t.Errorf("[!] uploading with the wrong checksum returned %v; want a 400", err)
+	}
+}
+
+func TestClientLoadsEveryPage(t *testing.T) {
+	server := cloudsmithtest.NewServer()
+	defer server.Close()
+
+	for i := 0; i < 250; i++ {
+		server.Fake.Add(cloudsmith_api.ModelPackage{
+			Name:     "org/package",
+			Version:  fmt.Sprintf("1.0.%d", i),
+			Filename: fmt.Sprintf("org-package-%d.zip", i),
+		})
+	}
+
+	server.Fake.Add(cloudsmith_api.ModelPackage{
+		Name:      "org/package",
+		Version:   "2.0.0",
+		StatusStr: "Failed",
+	})
+
+	client := cloudsmith.NewClientWithBasePath(cloudsmithtest.ApiKey, server.BasePath())
+
+	if err := client.LoadPackages("org", "repo"); err != nil {
+		t.Fatalf("[!] LoadPackages() returned %v", err)
+	}
+
+	if len(client.KnownVersions) != 250 {
+		t.Errorf("[!] LoadPackages() loaded %d packages; want 250", len(client.KnownVersions))
+	}
+
+	if client.IsAwareOfPackage("org/package", "2.0.0") {
+		t.Errorf("[!] LoadPackages() loaded a failed package")
+	}
+
+	failed, err := client.ListPackages("org", "repo", "status:failed")
+
+	if err != nil || len(failed) != 1 {
+		t.Fatalf("[!] ListPackages(status:failed) = %d packages, %v; want 1", len(failed), err)
+	}
+
+	if err := client.ResyncPackage("org", "repo", failed[0].SlugPerm); err != nil {
+		t.Errorf("[!] ResyncPackage() returned %v", err)
+	}
+}
+
+func TestClientRequiresApiKey(t *testing.T) {
+	server := cloudsmithtest.NewServer()
+	defer server.Close()
+
+	client := cloudsmith.NewClientWithBasePath("wrong", server.BasePath())
+
+	err := client.LoadPackages("org", "repo")
+
+	if cmError, ok := err.(*cloudsmith.Error); !ok || cmError.StatusCode != 401 {
+		t.Errorf("[!] LoadPackages() with the wrong api key returned %v; want a 401", err)
+	}
+}
+
+func TestClientRetriesTransientFailures(t *testing.T) {
+	// The rate limit reset is tested first, before this second has passed
+	inOneSecond := strconv.FormatInt(time.Now().Add(time.Second).Unix()+1, 10)
+
+	tests := []struct {
+		name     string
+		failures int
+		status   int
+		header   http.Header
+		requests int
+		fails    bool
+		waits    time.Duration
+	}{
+		{"rate limit reset", 1, 429, http.Header{"X-Ratelimit-Remaining": {"0"}, "X-Ratelimit-Reset": {inOneSecond}}, 2, false, time.Second},
+		{"server errors", 2, 503, nil, 3, false, 0},
+		{"too many server errors", 5, 500, nil, 4, true, 0},
+		{"not implemented", 1, 501, nil, 1, true, 0},
+		{"client errors", 1, 400, nil, 1, true, 0},
+		{"retry after", 1, 429, http.Header{"Retry-After": {"1"}}, 2, false, time.Second},
+	}
+
+	for _, test := range tests {
+		server := cloudsmithtest.NewServer()
+
+		client := cloudsmith.NewClientWithBasePath(cloudsmithtest.ApiKey, server.BasePath())
+		client.MaxRetries = 3
+		client.RetryBackoff = time.Millisecond
+
+		server.FailRequests(test.failures, test.status, test.header)
+
+		started := time.Now()
+
+		_, err := client.RemoteCheckPackageExists("org", "repo", "org/package", "1.0.0")
+
+		if (err != nil) != test.fails {
+			t.Errorf("[!] %s: RemoteCheckPackageExists() returned %v", test.name, err)
+		}
+
+		if cmError, ok := err.(*cloudsmith.Error); test.fails && (!ok || cmError.StatusCode != test.status) {
+			t.Errorf("[!] %s: RemoteCheckPackageExists() returned %v; want a %d", test.name, err, test.status)
+		}
+
+		if server.Requests() != test.requests {
+			t.Errorf("[!] %s: %d requests were made; want %d", test.name, server.Requests(), test.requests)
+		}
+
+		if elapsed := time.Since(started); elapsed < test.waits {
+			t.Errorf("[!] %s: retried after %v; want at least %v", test.name, elapsed, test.waits)
+		}
+
+		server.Close()
+	}
+}
+
+func TestClientOnlyRetriesUploadsWhenRateLimited(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-client")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	artifact := writeArtifact(t, dir, "org-package-aaa.zip", `{"name": "org/package", "version": "1.0.0"}`)
+
+	tests := []struct {
+		name     string
+		status   int
+		requests int
+		fails    bool
+	}{
+		{"rate limited", 429, 3, false},
+		{"server error", 502, 1, true},
+	}
+
+	for _, test := range tests {
+		server := cloudsmithtest.NewServer()
+
+		client := cloudsmith.NewClientWithBasePath(cloudsmithtest.ApiKey, server.BasePath())
+		client.RetryBackoff = time.Millisecond
+
+		server.FailRequests(1, test.status, nil)
+
+		_, err := client.UploadComposerPackage("org", "repo", cloudsmith.Artifact{Path: artifact}, false)
+
+		if (err != nil) != test.fails {
+			t.Errorf("[!] %s: UploadComposerPackage() returned %v", test.name, err)
+		}
+
+		if server.Requests() != test.requests {
+			t.Errorf("[!] %s: %d requests were made; want %d", test.name, server.Requests(), test.requests)
+		}
+
+		server.Close()
+	}
+}
+
+func TestClientWaitsForRateLimitReset(t *testing.T) {
+	server := cloudsmithtest.NewServer()
+	defer server.Close()
+
+	client := cloudsmith.NewClientWithBasePath(cloudsmithtest.ApiKey, server.BasePath())
+
+	server.FailRequests(1, 404, http.Header{
+		"X-Ratelimit-Remaining": {"0"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(time.Now().Unix()+1, 10)},
+	})
+
+	if _, err := client.RemoteCheckPackageExists("org", "repo", "org/package", "1.0.0"); err != nil {
+		t.Fatalf("[!] RemoteCheckPackageExists() returned %v", err)
+	}
+
+	started := time.Now()
+
+	if _, err := client.RemoteCheckPackageExists("org", "repo", "org/package", "1.0.0"); err != nil {
+		t.Fatalf("[!] RemoteCheckPackageExists() returned %v", err)
+	}
+
+	if time.Since(started) < 100*time.Millisecond {
+		t.Errorf("[!] a request was made before the rate limit reset")
+	}
+}
@@ -0,0 +1,527 @@
+// Package cloudsmithtest provides an in-memory Cloudsmith registry and a
+// stand-in for the Cloudsmith API, for testing code that publishes packages.
+package cloudsmithtest
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrPackageExists = errors.New("a package with the same version already exists")
+
+// Upload is an artifact that was uploaded to a Fake.
+type Upload struct {
+	Filename  string
+	Republish bool
+	// ComposerJson is the composer.json inside the artifact.
+	ComposerJson map[string]interface{}
+}
+
+// Fake is an in-memory Registry holding a single Cloudsmith repository, the
+// owner and repository arguments are ignored. Uploaded artifacts are read for
+// their composer.json, like Cloudsmith does, and are completed right away
+// unless processing has been set up to take longer or fail.
+type Fake struct {
+	mutex      sync.Mutex
+	packages   []cloudsmith_api.ModelPackage
+	nextId     int32
+	processing map[string]int
+
+	Uploads  []Upload
+	Deleted  []cloudsmith_api.ModelPackage
+	Resynced []string
+	// StatusChecks is how many times the status of a package was checked.
+	StatusChecks int
+	// Err, when set, is returned by every call.
+	Err error
+	// ProcessingChecks is how many times the status of an uploaded package
+	// is checked before it has been processed.
+	ProcessingChecks int
+	// ProcessingFailure, when set, fails processing uploaded packages with it
+	// as the reason.
+	ProcessingFailure string
+}
+
+var _ cloudsmith.Registry = (*Fake)(nil)
+
+func NewFake() *Fake {
+	return &Fake{
+		processing: make(map[string]int),
+	}
+}
+
+// Add puts a package into the registry as if it had been uploaded before.
+// Missing identifiers and statuses are filled in.
+func (f *Fake) Add(pkg cloudsmith_api.ModelPackage) cloudsmith_api.ModelPackage {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.add(pkg)
+}
+
+// Packages returns every package in the registry.
+func (f *Fake) Packages() []cloudsmith_api.ModelPackage {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return append([]cloudsmith_api.ModelPackage{}, f.packages...)
+}
+
+// Package returns the package with the given name and version.
+func (f *Fake) Package(name, version string) (cloudsmith_api.ModelPackage, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	index := f.find(name, version)
+
+	if index < 0 {
+		return cloudsmith_api.ModelPackage{}, false
+	}
+
+	return f.packages[index], true
+}
+
+func (f *Fake) LoadPackages(owner, repo string) error {
+	return f.Err
+}
+
+func (f *Fake) ListPackages(owner, repo, query string) ([]cloudsmith_api.ModelPackage, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	return f.list(query), nil
+}
+
+func (f *Fake) PackageExists(owner, repo, name, version string) (bool, error) {
+	return f.RemoteCheckPackageExists(owner, repo, name, version)
+}
+
+func (f *Fake) RemoteCheckPackageExists(owner, repo, name, version string) (bool, error) {
+	filename, err := f.PackageFilename(owner, repo, name, version)
+
+	return filename != "", err
+}
+
+func (f *Fake) PackageFilename(owner, repo, name, version string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.Err != nil {
+		return "", f.Err
+	}
+
+	index := f.find(name, version)
+
+	if index < 0 {
+		return "", nil
+	}
+
+	return f.packages[index].Filename, nil
+}
+
+// DeletePackageIfExists deletes the completed package of a version, like the
+// Client only packages that have been processed are looked for.
+func (f *Fake) DeletePackageIfExists(owner, repo, name, version string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	index := f.find(name, version)
+
+	if index >= 0 && f.packages[index].IsSyncCompleted {
+		f.remove(index)
+	}
+
+	return nil
+}
+
+func (f *Fake) DeletePackage(owner, repo, identifier string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	for i, pkg := range f.packages {
+		if pkg.SlugPerm == identifier {
+			f.remove(i)
+
+			return nil
+		}
+	}
+
+	return errors.New("package " + identifier + " not found")
+}
+
+// UploadComposerPackage publishes an artifact, checking it against its md5
+// checksum when one is given.
+func (f *Fake) UploadComposerPackage(owner, repo string, artifact cloudsmith.Artifact, republish bool) (*cloudsmith_api.ModelPackage, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	contents, err := ioutil.ReadFile(artifact.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sum := md5.Sum(contents); artifact.Md5 != "" && artifact.Md5 != hex.EncodeToString(sum[:]) {
+		return nil, errors.New("the md5 checksum of " + artifact.Path + " doesn't match")
+	}
+
+	pkg, err := f.Publish(filepath.Base(artifact.Path), contents, republish)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkg, nil
+}
+
+func (f *Fake) ResyncPackage(owner, repo, identifier string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.Err != nil {
+		return f.Err
+	}
+
+	for i, pkg := range f.packages {
+		if pkg.SlugPerm == identifier {
+			f.packages[i] = completed(pkg)
+			f.Resynced = append(f.Resynced, identifier)
+
+			delete(f.processing, identifier)
+
+			return nil
+		}
+	}
+
+	return errors.New("package " + identifier + " not found")
+}
+
+// PackageStatus returns the status of a package, moving uploaded packages
+// along each time it's checked.
+func (f *Fake) PackageStatus(owner, repo, identifier string) (*cloudsmith_api.PackageStatus, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.StatusChecks++
+
+	if f.Err != nil {
+		return nil, f.Err
+	}
+
+	for i, pkg := range f.packages {
+		if pkg.SlugPerm != identifier {
+			continue
+		}
+
+		if checks, ok := f.processing[identifier]; ok {
+			if checks > 0 {
+				f.processing[identifier] = checks - 1
+			} else {
+				delete(f.processing, identifier)
+
+				if f.ProcessingFailure != "" {
+					f.packages[i] = failed(pkg, f.ProcessingFailure)
+				} else {
+					f.packages[i] = completed(pkg)
+				}
+			}
+		}
+
+		return status(f.packages[i]), nil
+	}
+
+	return nil, errors.New("package " + identifier + " not found")
+}
+
+// Publish adds an uploaded artifact as a package, named after the
+// composer.json inside it. Without republish, uploading a version that
+// already exists fails.
+func (f *Fake) Publish(filename string, contents []byte, republish bool) (cloudsmith_api.ModelPackage, error) {
+	composerJson, err := readComposerJson(filename, contents)
+
+	if err != nil {
+		return cloudsmith_api.ModelPackage{}, err
+	}
+
+	name, _ := composerJson["name"].(string)
+	version, _ := composerJson["version"].(string)
+
+	if name == "" || version == "" {
+		return cloudsmith_api.ModelPackage{}, errors.New("composer.json needs a name and version")
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if index := f.find(name, version); index >= 0 {
+		if !republish {
+			return cloudsmith_api.ModelPackage{}, ErrPackageExists
+		}
+
+		f.remove(index)
+	}
+
+	f.Uploads = append(f.Uploads, Upload{
+		Filename:     filename,
+		Republish:    republish,
+		ComposerJson: composerJson,
+	})
+
+	pkg := cloudsmith_api.ModelPackage{
+		Name:     name,
+		Version:  version,
+		Filename: filename,
+		Format:   "composer",
+	}
+
+	if f.ProcessingChecks > 0 || f.ProcessingFailure != "" {
+		pkg = awaiting(pkg)
+	}
+
+	pkg = f.add(pkg)
+
+	if !pkg.IsSyncCompleted {
+		f.processing[pkg.SlugPerm] = f.ProcessingChecks
+	}
+
+	return pkg, nil
+}
+
+func (f *Fake) add(pkg cloudsmith_api.ModelPackage) cloudsmith_api.ModelPackage {
+	f.nextId++
+
+	if pkg.Identifier == 0 {
+		pkg.Identifier = f.nextId
+	}
+
+	if pkg.SlugPerm == "" {
+		pkg.SlugPerm = fmt.Sprintf("pkg%d", pkg.Identifier)
+	}
+
+	if pkg.Format == "" {
+		pkg.Format = "composer"
+	}
+
+	if pkg.StatusStr == "" {
+		pkg = completed(pkg)
+	}
+
+	f.packages = append(f.packages, pkg)
+
+	return pkg
+}
+
+func (f *Fake) remove(index int) {
+	delete(f.processing, f.packages[index].SlugPerm)
+
+	f.Deleted = append(f.Deleted, f.packages[index])
+	f.packages = append(f.packages[:index], f.packages[index+1:]...)
+}
+
+func (f *Fake) find(name, version string) int {
+	for i, pkg := range f.packages {
+		if pkg.Name == name && pkg.Version == version {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (f *Fake) list(query string) []cloudsmith_api.ModelPackage {
+	var packages []cloudsmith_api.ModelPackage
+
+	for _, pkg := range f.packages {
+		if matchesQuery(pkg, query) {
+			packages = append(packages, pkg)
+		}
+	}
+
+	return packages
+}
+
+func awaiting(pkg cloudsmith_api.ModelPackage) cloudsmith_api.ModelPackage {
+	pkg.Status = 1
+	pkg.StatusStr = "Awaiting Sync"
+	pkg.StatusReason = ""
+	pkg.IsSyncAwaiting = true
+	pkg.IsSyncCompleted = false
+	pkg.IsSyncFailed = false
+	pkg.SyncProgress = 0
+
+	return pkg
+}
+
+func completed(pkg cloudsmith_api.ModelPackage) cloudsmith_api.ModelPackage {
+	pkg.Status = 4
+	pkg.StatusStr = "Completed"
+	pkg.StatusReason = ""
+	pkg.IsSyncAwaiting = false
+	pkg.IsSyncCompleted = true
+	pkg.IsSyncFailed = false
+	pkg.IsSyncInProgress = false
+	pkg.SyncProgress = 100
+
+	return pkg
+}
+
+func failed(pkg cloudsmith_api.ModelPackage, reason string) cloudsmith_api.ModelPackage {
+	pkg.Status = 5
+	pkg.StatusStr = "Failed"
+	pkg.StatusReason = reason
+	pkg.IsSyncAwaiting = false
+	pkg.IsSyncCompleted = false
+	pkg.IsSyncFailed = true
+	pkg.IsSyncInProgress = false
+
+	return pkg
+}
+
+func status(pkg cloudsmith_api.ModelPackage) *cloudsmith_api.PackageStatus {
+	return &cloudsmith_api.PackageStatus{
+		IsSyncAwaiting:   pkg.IsSyncAwaiting,
+		IsSyncCompleted:  pkg.IsSyncCompleted,
+		IsSyncFailed:     pkg.IsSyncFailed,
+		IsSyncInFlight:   pkg.IsSyncInFlight,
+		IsSyncInProgress: pkg.IsSyncInProgress,
+		StageStr:         pkg.StageStr,
+		StatusStr:        pkg.StatusStr,
+		StatusReason:     pkg.StatusReason,
+		SyncProgress:     pkg.SyncProgress,
+	}
+}
+
+// matchesQuery supports the field:value terms of Cloudsmith's search syntax
+// that are used when syncing.
+func matchesQuery(pkg cloudsmith_api.ModelPackage, query string) bool {
+	for _, term := range strings.Fields(query) {
+		parts := strings.SplitN(term, ":", 2)
+
+		if len(parts) != 2 {
+			continue
+		}
+
+		var value string
+
+		switch parts[0] {
+		case "name":
+			value = pkg.Name
+		case "version":
+			value = pkg.Version
+		case "status":
+			value = pkg.StatusStr
+		case "format":
+			value = pkg.Format
+		default:
+			continue
+		}
+
+		if !strings.EqualFold(value, parts[1]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func readComposerJson(filename string, contents []byte) (map[string]interface{}, error) {
+	var raw []byte
+	var err error
+
+	switch {
+	case strings.HasSuffix(filename, ".zip"):
+		raw, err = readZipFile(contents, "composer.json")
+	case strings.HasSuffix(filename, ".tar.gz"):
+		var reader io.Reader
+
+		reader, err = gzip.NewReader(bytes.NewReader(contents))
+
+		if err == nil {
+			raw, err = readTarFile(reader, "composer.json")
+		}
+	case strings.HasSuffix(filename, ".tar"):
+		raw, err = readTarFile(bytes.NewReader(contents), "composer.json")
+	default:
+		err = errors.New("unsupported artifact " + filename)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var composerJson map[string]interface{}
+
+	return composerJson, json.Unmarshal(raw, &composerJson)
+}
+
+func readZipFile(contents []byte, name string) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(contents), int64(len(contents)))
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range archive.File {
+		if file.Name != name {
+			continue
+		}
+
+		reader, err := file.Open()
+
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		return ioutil.ReadAll(reader)
+	}
+
+	return nil, errors.New(name + " not found in artifact")
+}
+
+func readTarFile(reader io.Reader, name string) ([]byte, error) {
+	archive := tar.NewReader(reader)
+
+	for {
+		header, err := archive.Next()
+
+		if err == io.EOF {
+			return nil, errors.New(name + " not found in artifact")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if header.Name == name {
+			return ioutil.ReadAll(archive)
+		}
+	}
+}
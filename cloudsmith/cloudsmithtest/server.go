@@ -0,0 +1,347 @@
+package cloudsmithtest
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	"github.com/gorilla/mux"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+)
+
+// ApiKey is the key the Server expects in the X-Api-Key header.
+const ApiKey = "test-api-key"
+
+// Server is a stand-in for the parts of the Cloudsmith API used when syncing,
+// including the pre-signed upload, backed by a Fake.
+type Server struct {
+	*httptest.Server
+	Fake *Fake
+
+	mutex    sync.Mutex
+	files    map[string]*pendingFile
+	nextId   int
+	requests int
+	failures []failure
+}
+
+type failure struct {
+	Status int
+	Header http.Header
+}
+
+type pendingFile struct {
+	Filename string
+	Md5      string
+	Contents []byte
+	Uploaded bool
+}
+
+// NewServer starts a Server, which has to be closed once done with.
+func NewServer() *Server {
+	s := &Server{
+		Fake:  NewFake(),
+		files: make(map[string]*pendingFile),
+	}
+
+	router := mux.NewRouter()
+
+	api := router.PathPrefix("/v1").Subrouter()
+	api.Use(s.authenticate)
+	api.HandleFunc("/files/{owner}/{repo}/", s.createFile).Methods("POST")
+	api.HandleFunc("/packages/{owner}/{repo}/", s.listPackages).Methods("GET")
+	api.HandleFunc("/packages/{owner}/{repo}/upload/composer/", s.uploadComposer).Methods("POST")
+	api.HandleFunc("/packages/{owner}/{repo}/{identifier}/", s.readPackage).Methods("GET")
+	api.HandleFunc("/packages/{owner}/{repo}/{identifier}/", s.deletePackage).Methods("DELETE")
+	api.HandleFunc("/packages/{owner}/{repo}/{identifier}/resync/", s.resyncPackage).Methods("POST")
+	api.HandleFunc("/packages/{owner}/{repo}/{identifier}/status/", s.packageStatus).Methods("GET")
+
+	// The file itself goes to S3, which doesn't use the api key
+	router.HandleFunc("/upload/{identifier}", s.uploadFile).Methods("POST")
+
+	s.Server = httptest.NewServer(router)
+
+	return s
+}
+
+// BasePath is the API location to pass to cloudsmith.NewClientWithBasePath.
+func (s *Server) BasePath() string {
+	return s.URL + "/v1"
+}
+
+// FailRequests makes the next count API requests fail with the given status
+// and headers, like Cloudsmith does when rate limiting or having trouble.
+func (s *Server) FailRequests(count, status int, header http.Header) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i := 0; i < count; i++ {
+		s.failures = append(s.failures, failure{Status: status, Header: header})
+	}
+}
+
+// Requests returns how many API requests have been made, including failed
+// ones.
+func (s *Server) Requests() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.requests
+}
+
+// authenticate checks the api key of API requests, once any failures queued
+// by FailRequests have been served.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mutex.Lock()
+		s.requests++
+
+		var fail *failure
+
+		if len(s.failures) > 0 {
+			fail = &s.failures[0]
+			s.failures = s.failures[1:]
+		}
+		s.mutex.Unlock()
+
+		if fail != nil {
+			for key, values := range fail.Header {
+				w.Header()[key] = values
+			}
+
+			writeError(w, fail.Status, http.StatusText(fail.Status))
+			return
+		}
+
+		if r.Header.Get("X-Api-Key") != ApiKey {
+			writeError(w, http.StatusUnauthorized, "Invalid API key.")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) createFile(w http.ResponseWriter, r *http.Request) {
+	var request cloudsmith_api.FilesCreate
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	s.nextId++
+	identifier := fmt.Sprintf("file%d", s.nextId)
+	s.files[identifier] = &pendingFile{
+		Filename: request.Filename,
+		Md5:      request.Md5Checksum,
+	}
+	s.mutex.Unlock()
+
+	writeJson(w, http.StatusAccepted, cloudsmith_api.PackageFileUpload{
+		Identifier: identifier,
+		UploadUrl:  s.URL + "/upload/" + identifier,
+		UploadFields: map[string]string{
+			"key": identifier,
+		},
+	})
+}
+
+// uploadFile stands in for the pre-signed S3 upload, which needs the upload
+// fields and the length of the body up front.
+func (s *Server) uploadFile(w http.ResponseWriter, r *http.Request) {
+	identifier := mux.Vars(r)["identifier"]
+
+	s.mutex.Lock()
+	file, ok := s.files[identifier]
+	s.mutex.Unlock()
+
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	if r.ContentLength < 0 {
+		w.WriteHeader(http.StatusLengthRequired)
+		return
+	}
+
+	if r.FormValue("key") != identifier {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	part, _, err := r.FormFile("file")
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	defer part.Close()
+
+	contents, err := ioutil.ReadAll(part)
+
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	sum := md5.Sum(contents)
+
+	if file.Md5 != "" && file.Md5 != hex.EncodeToString(sum[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	s.mutex.Lock()
+	file.Contents = contents
+	file.Uploaded = true
+	s.mutex.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) uploadComposer(w http.ResponseWriter, r *http.Request) {
+	var request cloudsmith_api.PackagesUploadComposer
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mutex.Lock()
+	file, ok := s.files[request.PackageFile]
+	s.mutex.Unlock()
+
+	if !ok || !file.Uploaded {
+		writeError(w, http.StatusBadRequest, "The package file hasn't been uploaded.")
+		return
+	}
+
+	pkg, err := s.Fake.Publish(file.Filename, file.Contents, request.Republish)
+
+	if err == ErrPackageExists {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	writeJson(w, http.StatusCreated, pkg)
+}
+
+// listPackages pages like Cloudsmith does, responding with a 404 for pages
+// past the end.
+func (s *Server) listPackages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := strconv.Atoi(query.Get("page"))
+
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(query.Get("page_size"))
+
+	if err != nil || pageSize < 1 {
+		pageSize = 30
+	}
+
+	packages, err := s.Fake.ListPackages("", "", query.Get("query"))
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	start := (page - 1) * pageSize
+
+	if start > 0 && start >= len(packages) {
+		writeError(w, http.StatusNotFound, "Invalid page.")
+		return
+	}
+
+	end := start + pageSize
+
+	if end > len(packages) {
+		end = len(packages)
+	}
+
+	writeJson(w, http.StatusOK, append([]cloudsmith_api.ModelPackage{}, packages[start:end]...))
+}
+
+func (s *Server) readPackage(w http.ResponseWriter, r *http.Request) {
+	pkg, ok := s.findPackage(mux.Vars(r)["identifier"])
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	writeJson(w, http.StatusOK, pkg)
+}
+
+func (s *Server) deletePackage(w http.ResponseWriter, r *http.Request) {
+	if err := s.Fake.DeletePackage("", "", mux.Vars(r)["identifier"]); err != nil {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) resyncPackage(w http.ResponseWriter, r *http.Request) {
+	identifier := mux.Vars(r)["identifier"]
+
+	if err := s.Fake.ResyncPackage("", "", identifier); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	pkg, _ := s.findPackage(identifier)
+
+	writeJson(w, http.StatusOK, pkg)
+}
+
+func (s *Server) packageStatus(w http.ResponseWriter, r *http.Request) {
+	status, err := s.Fake.PackageStatus("", "", mux.Vars(r)["identifier"])
+
+	if err != nil {
+		writeError(w, http.StatusNotFound, "Not found.")
+		return
+	}
+
+	writeJson(w, http.StatusOK, status)
+}
+
+func (s *Server) findPackage(identifier string) (cloudsmith_api.ModelPackage, bool) {
+	for _, pkg := range s.Fake.Packages() {
+		if pkg.SlugPerm == identifier {
+			return pkg, true
+		}
+	}
+
+	return cloudsmith_api.ModelPackage{}, false
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, detail string) {
+	writeJson(w, status, map[string]string{
+		"detail": detail,
+	})
+}
@@ -0,0 +1,60 @@
+package cloudsmith
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	"net/http"
+)
+
+// Error is a failed Cloudsmith API request.
+type Error struct {
+	StatusCode int    `json:"-"`
+	Detail     string `json:"detail"`
+}
+
+func (e *Error) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("cloudsmith responded with %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+
+	return fmt.Sprintf("cloudsmith responded with %d: %s", e.StatusCode, e.Detail)
+}
+
+// IsNotFound reports whether err is a 404 from Cloudsmith, which is also how
+// it responds to pages past the end of a list.
+func IsNotFound(err error) bool {
+	cmError, ok := err.(*Error)
+
+	return ok && cmError.StatusCode == http.StatusNotFound
+}
+
+func checkForCloudsmithRequestError(response *cloudsmith_api.APIResponse, err error) error {
+	// Without a response the request never made it to Cloudsmith
+	if response == nil || response.Response == nil {
+		return err
+	}
+
+	// Check for 4xx 5xx responses as those *should* hopefully be in the error
+	// format described in their documentation :)
+	if response.StatusCode >= 400 {
+		cmError := &Error{}
+
+		json.Unmarshal(response.Payload, cmError)
+
+		cmError.StatusCode = response.StatusCode
+
+		return cmError
+	}
+
+	if response.StatusCode >= 300 {
+		return &Error{StatusCode: response.StatusCode, Detail: "api request got a redirect back for some reason"}
+	}
+
+	if response.StatusCode < 200 {
+		return &Error{StatusCode: response.StatusCode, Detail: "request got a status code the 100 range"}
+	}
+
+	// The response was fine, but decoding it may not have been
+	return err
+}
@@ -0,0 +1,39 @@
+package cloudsmith
+
+import (
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+)
+
+// Registry is what syncing needs from Cloudsmith. Client implements it against
+// the API, while cloudsmithtest provides an in-memory fake and a stand-in API
+// server for tests.
+type Registry interface {
+	// LoadPackages caches the completed composer packages of a repository, so
+	// PackageExists and PackageFilename don't have to ask Cloudsmith.
+	LoadPackages(owner, repo string) error
+	// ListPackages returns every package matching a Cloudsmith search query.
+	ListPackages(owner, repo, query string) ([]cloudsmith_api.ModelPackage, error)
+	PackageExists(owner, repo, name, version string) (bool, error)
+	RemoteCheckPackageExists(owner, repo, name, version string) (bool, error)
+	PackageFilename(owner, repo, name, version string) (string, error)
+	DeletePackageIfExists(owner, repo, name, version string) error
+	// DeletePackage deletes a package whatever its status, identifier is its
+	// slug_perm.
+	DeletePackage(owner, repo, identifier string) error
+	UploadComposerPackage(owner, repo string, artifact Artifact, republish bool) (*cloudsmith_api.ModelPackage, error)
+	// ResyncPackage asks Cloudsmith to process a package again, identifier
+	// is its slug_perm.
+	ResyncPackage(owner, repo, identifier string) error
+	// PackageStatus returns how far along Cloudsmith is with processing a
+	// package, identifier is its slug_perm.
+	PackageStatus(owner, repo, identifier string) (*cloudsmith_api.PackageStatus, error)
+}
+
+// Artifact is a built dist to be uploaded.
+type Artifact struct {
+	Path string
+	// Md5 is the hex checksum of the file, it's calculated when empty.
+	Md5 string
+}
+
+var _ Registry = (*Client)(nil)
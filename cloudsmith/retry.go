@@ -0,0 +1,178 @@
+package cloudsmith
+
+import (
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxRetries   = 5
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = 30 * time.Second
+	// maxRateLimitWait bounds how long a rate limit is waited out for, in case
+	// Cloudsmith sends a reset time that is far off
+	maxRateLimitWait = 5 * time.Minute
+)
+
+// call performs an idempotent API request, retrying it with exponential
+// backoff when Cloudsmith is rate limiting or briefly unavailable. Once the
+// rate limit has been used up, the next request waits for it to reset.
+func (c *Client) call(request func() (*cloudsmith_api.APIResponse, error)) error {
+	return c.retry(request, shouldRetry)
+}
+
+// create performs an API request that creates something, like a file or a
+// package. It's only retried when rate limited, as a server error or dropped
+// connection may come after Cloudsmith has created it, and trying again
+// would create it twice.
+func (c *Client) create(request func() (*cloudsmith_api.APIResponse, error)) error {
+	return c.retry(request, isRateLimited)
+}
+
+func (c *Client) retry(request func() (*cloudsmith_api.APIResponse, error), shouldRetry func(*cloudsmith_api.APIResponse, error) bool) error {
+	backoff := c.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		c.waitForRateLimit()
+
+		response, err := request()
+
+		c.trackRateLimit(response)
+
+		if attempt >= c.MaxRetries || !shouldRetry(response, err) {
+			return checkForCloudsmithRequestError(response, err)
+		}
+
+		time.Sleep(retryDelay(response, backoff))
+
+		backoff *= 2
+
+		if backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// shouldRetry reports whether a request failed in a way that may succeed
+// when tried again.
+func shouldRetry(response *cloudsmith_api.APIResponse, err error) bool {
+	if response == nil || response.Response == nil {
+		return err != nil
+	}
+
+	switch {
+	case response.StatusCode == http.StatusTooManyRequests:
+		return true
+	case response.StatusCode == http.StatusNotImplemented:
+		return false
+	case response.StatusCode >= 500:
+		return true
+	}
+
+	return false
+}
+
+// isRateLimited reports whether a request was turned away by the rate limit,
+// so Cloudsmith didn't act on it.
+func isRateLimited(response *cloudsmith_api.APIResponse, err error) bool {
+	return response != nil && response.Response != nil && response.StatusCode == http.StatusTooManyRequests
+}
+
+// retryDelay returns how long to wait before retrying, as asked for by the
+// Retry-After or X-RateLimit-Reset headers, or the backoff otherwise.
+func retryDelay(response *cloudsmith_api.APIResponse, backoff time.Duration) time.Duration {
+	if response == nil || response.Response == nil {
+		return backoff
+	}
+
+	if delay, ok := parseRetryAfter(response.Header.Get("Retry-After")); ok {
+		return capRateLimitWait(delay)
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests {
+		if reset, ok := rateLimitReset(response.Header); ok {
+			return capRateLimitWait(time.Until(reset))
+		}
+	}
+
+	return backoff
+}
+
+func (c *Client) waitForRateLimit() {
+	c.rateLimitMutex.Lock()
+	wait := time.Until(c.rateLimitReset)
+	c.rateLimitMutex.Unlock()
+
+	if wait > 0 {
+		time.Sleep(capRateLimitWait(wait))
+	}
+}
+
+// trackRateLimit remembers when the rate limit resets once a response says
+// there are no requests left.
+func (c *Client) trackRateLimit(response *cloudsmith_api.APIResponse) {
+	if response == nil || response.Response == nil {
+		return
+	}
+
+	if response.Header.Get("X-RateLimit-Remaining") != "0" {
+		return
+	}
+
+	reset, ok := rateLimitReset(response.Header)
+
+	if !ok {
+		return
+	}
+
+	c.rateLimitMutex.Lock()
+	defer c.rateLimitMutex.Unlock()
+
+	if reset.After(c.rateLimitReset) {
+		c.rateLimitReset = reset
+	}
+}
+
+// rateLimitReset reads X-RateLimit-Reset, which holds the unix time the rate
+// limit resets at.
+func rateLimitReset(header http.Header) (time.Time, bool) {
+	seconds, err := strconv.ParseFloat(header.Get("X-RateLimit-Reset"), 64)
+
+	if err != nil || seconds <= 0 {
+		return time.Time{}, false
+	}
+
+	return time.Unix(0, int64(seconds*float64(time.Second))), true
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of
+// seconds or a date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+
+	return 0, false
+}
+
+func capRateLimitWait(wait time.Duration) time.Duration {
+	if wait < 0 {
+		return 0
+	}
+
+	if wait > maxRateLimitWait {
+		return maxRateLimitWait
+	}
+
+	return wait
+}
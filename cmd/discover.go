@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/discovery"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/githubapp"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+func init() {
+	rootCmd.AddCommand(discoverCmd)
+}
+
+var discoverCmd = &cobra.Command{
+	Use:   "discover",
+	Short: "Lists the configured and discovered repositories",
+	Run: func(cmd *cobra.Command, args []string) {
+		static := len(config.Repositories)
+
+		err := discoverRepositories(git.NewBackend(config))
+		exitOnError(err)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "REPOSITORY\tSOURCE")
+
+		for i, repository := range config.Repositories {
+			source := "config"
+
+			if i >= static {
+				source = "discovered"
+			}
+
+			fmt.Fprintf(tw, "%s\t%s\n", repository.Url, source)
+		}
+
+		exitOnError(tw.Flush())
+	},
+}
+
+// discoverRepositories adds the repositories found by the configured discovery
+// sources to config.Repositories.
+func discoverRepositories(backend *git.Backend) error {
+	if len(config.Discovery) == 0 {
+		return nil
+	}
+
+	var app *githubapp.App
+
+	if config.GithubApp != nil {
+		var err error
+
+		app, err = backend.GithubApp()
+
+		if err != nil {
+			return err
+		}
+	}
+
+	repositories, err := discovery.NewDiscoverer(app).Discover(config)
+
+	if err != nil {
+		return err
+	}
+
+	config.Repositories = repositories
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cache"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/journal"
+	"github.com/spf13/cobra"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var MaxCacheSize int64
+var MaxCacheAge time.Duration
+
+func init() {
+	gcCmd.Flags().Int64Var(&MaxCacheSize, "max-size", 0, "Maximum size of the cached artifacts in MB, least recently used ones are removed first (0 for no limit)")
+	gcCmd.Flags().DurationVar(&MaxCacheAge, "max-age", 0, "Remove cached artifacts that haven't been used for this long, e.g. 720h (0 for no limit)")
+	rootCmd.AddCommand(gcCmd)
+}
+
+var gcCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Removes cached artifacts and clones that are no longer needed",
+	Run: func(cmd *cobra.Command, args []string) {
+		// Discovered repositories are configured too, their clones are kept
+		err := discoverRepositories(git.NewBackend(config))
+		exitOnError(err)
+
+		artifactCache, err := cache.Open(config.GetArtifactIndexPath())
+		exitOnError(err)
+
+		var freed int64
+
+		removed, err := artifactCache.Prune(keepArtifact, cache.Limits{
+			MaxSize: MaxCacheSize * 1024 * 1024,
+			MaxAge:  MaxCacheAge,
+		}, dryRun)
+
+		for _, entry := range removed {
+			fmt.Printf("Removing artifact of %s@%s (%s)\n", entry.Package, entry.Version, entry.Ref)
+			freed += entry.Size
+		}
+
+		exitOnError(err)
+
+		size, err := removeUntrackedArtifacts(artifactCache)
+		freed += size
+		exitOnError(err)
+
+		size, err = removeUnconfiguredClones()
+		freed += size
+		exitOnError(err)
+
+		fmt.Printf("Freed %.1f MB\n", float64(freed)/1024/1024)
+	},
+}
+
+// keepArtifact reports whether a cached artifact belongs to a ref that's still
+// synced by the configuration.
+func keepArtifact(entry cache.Entry) bool {
+	repoCfg, err := config.FindRepository(entry.Repository)
+
+	if err != nil {
+		return false
+	}
+
+	shouldSync, _, err := repoCfg.ShouldSyncRef(entry.Ref, entry.Branch)
+
+	return err == nil && shouldSync
+}
+
+// removeUntrackedArtifacts removes artifacts the cache doesn't know about,
+// like the ones left behind by older versions.
+func removeUntrackedArtifacts(artifactCache *cache.Index) (int64, error) {
+	artifactsDir := config.GetArtifactPath("")
+	tracked := make(map[string]bool)
+
+	for _, entry := range artifactCache.Entries() {
+		tracked[filepath.Clean(entry.Path)] = true
+		tracked[filepath.Dir(filepath.Clean(entry.Path))] = true
+	}
+
+	tracked[filepath.Clean(config.GetArtifactIndexPath())] = true
+	tracked[filepath.Clean(journal.LogPath(config.GetArtifactIndexPath()))] = true
+
+	return removeAllExcept(artifactsDir, tracked, "artifact")
+}
+
+// removeUnconfiguredClones removes the clones of repositories that are no
+// longer configured.
+func removeUnconfiguredClones() (int64, error) {
+	reposDir := config.GetRepoPath("")
+	configured := make(map[string]bool)
+
+	for _, repo := range config.Repositories {
+		repoDir, err := git.GitUrlToDirectory(repo.Url)
+
+		if err != nil {
+			return 0, err
+		}
+
+		configured[filepath.Clean(config.GetRepoPath(repoDir))] = true
+
+		// Clones that haven't been moved to their current directory yet are
+		// moved on the next sync rather than cloned again
+		if legacyDir := git.LegacyGitUrlToDirectory(repo.Url); legacyDir != "" {
+			configured[filepath.Clean(config.GetRepoPath(legacyDir))] = true
+		}
+	}
+
+	return removeAllExcept(reposDir, configured, "clone")
+}
+
+func removeAllExcept(dir string, keep map[string]bool, kind string) (int64, error) {
+	files, err := ioutil.ReadDir(dir)
+
+	if err != nil {
+		return 0, err
+	}
+
+	var freed int64
+
+	for _, file := range files {
+		path := filepath.Join(dir, file.Name())
+
+		if keep[filepath.Clean(path)] {
+			continue
+		}
+
+		size, err := diskUsage(path)
+
+		if err != nil {
+			return freed, err
+		}
+
+		fmt.Printf("Removing %s %s\n", kind, path)
+
+		if !dryRun {
+			if err := os.RemoveAll(path); err != nil {
+				return freed, err
+			}
+		}
+
+		freed += size
+	}
+
+	return freed, nil
+}
+
+func diskUsage(path string) (int64, error) {
+	var size int64
+
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size, err
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/spf13/cobra"
+	"os"
+	"text/tabwriter"
+)
+
+func init() {
+	rootCmd.AddCommand(githubAppCmd)
+}
+
+var githubAppCmd = &cobra.Command{
+	Use:   "github-app",
+	Short: "Lists the repositories the GitHub App installation can access",
+	Run: func(cmd *cobra.Command, args []string) {
+		if config.GithubApp == nil {
+			exitOnError(errors.New("githubApp is not configured"))
+		}
+
+		app, err := git.NewBackend(config).GithubApp()
+		exitOnError(err)
+
+		repositories, err := app.ListRepositories()
+		exitOnError(err)
+
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "REPOSITORY\tCLONE URL\tCONFIGURED")
+
+		for _, repository := range repositories {
+			_, err := config.FindRepository(repository.CloneURL, repository.SSHURL)
+
+			fmt.Fprintf(tw, "%s\t%s\t%v\n", repository.FullName, repository.CloneURL, err == nil)
+		}
+
+		exitOnError(tw.Flush())
+	},
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	"github.com/Lavoaster/cloudsmith-sync/sync"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+var PruneKeep []string
+var PruneTags bool
+
+func init() {
+	pruneCmd.Flags().StringSliceVar(&PruneKeep, "keep", nil, "Never prune these versions, or name@versions, on top of the ones in the config")
+	pruneCmd.Flags().BoolVar(&PruneTags, "tags", false, "Prune the packages of deleted tags too")
+	rootCmd.AddCommand(pruneCmd)
+}
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Deletes packages whose branch or tag no longer exists",
+	Run: func(cmd *cobra.Command, args []string) {
+		config.Prune.Keep = append(config.Prune.Keep, PruneKeep...)
+		config.Prune.Tags = config.Prune.Tags || PruneTags
+
+		backend := git.NewBackend(config)
+
+		err := discoverRepositories(backend)
+		exitOnError(err)
+
+		store, err := state.Open(config.GetStatePath())
+		exitOnError(err)
+
+		syncer := sync.NewSyncer(config, cloudsmith.NewClient(config.ApiKey), backend)
+		syncer.State = store
+		syncer.DryRun = dryRun
+		syncer.Logf = logf
+
+		results := syncer.PruneRepositories(config.Repositories)
+
+		fmt.Println()
+
+		err = report.WriteTable(os.Stdout, results)
+		exitOnError(err)
+
+		fmt.Println()
+
+		if dryRun {
+			fmt.Printf(
+				"%d would be pruned, %d kept, %d failed\n",
+				report.Count(results, report.WouldDelete),
+				report.Count(results, report.Skipped),
+				report.Count(results, report.Failed),
+			)
+		} else {
+			fmt.Printf(
+				"%d pruned, %d kept, %d failed\n",
+				report.Count(results, report.Deleted),
+				report.Count(results, report.Skipped),
+				report.Count(results, report.Failed),
+			)
+		}
+
+		if report.HasFailures(results) {
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	"github.com/Lavoaster/cloudsmith-sync/sync"
+	"github.com/spf13/cobra"
+	"os"
+)
+
+func init() {
+	rootCmd.AddCommand(retentionCmd)
+}
+
+var retentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Deletes packages the retention rules of their repository no longer keep",
+	Run: func(cmd *cobra.Command, args []string) {
+		backend := git.NewBackend(config)
+
+		err := discoverRepositories(backend)
+		exitOnError(err)
+
+		store, err := state.Open(config.GetStatePath())
+		exitOnError(err)
+
+		syncer := sync.NewSyncer(config, cloudsmith.NewClient(config.ApiKey), backend)
+		syncer.State = store
+		syncer.DryRun = dryRun
+		syncer.Logf = logf
+
+		results := syncer.ApplyRetention(config.Repositories)
+
+		fmt.Println()
+
+		err = report.WriteTable(os.Stdout, results)
+		exitOnError(err)
+
+		fmt.Println()
+
+		if dryRun {
+			fmt.Printf(
+				"%d would be deleted, %d failed\n",
+				report.Count(results, report.WouldDelete),
+				report.Count(results, report.Failed),
+			)
+		} else {
+			fmt.Printf(
+				"%d deleted, %d failed\n",
+				report.Count(results, report.Deleted),
+				report.Count(results, report.Failed),
+			)
+		}
+
+		if report.HasFailures(results) {
+			os.Exit(1)
+		}
+	},
+}
@@ -1,11 +1,26 @@
 package cmd
 
 import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cache"
 	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	"github.com/Lavoaster/cloudsmith-sync/sync"
 	"github.com/spf13/cobra"
+	"os"
+	"time"
 )
 
+var RetryNames []string
+var RetryMaxAge time.Duration
+var RetryRebuild bool
+
 func init() {
+	retryCmd.Flags().StringSliceVar(&RetryNames, "name", nil, "Only retry packages matching these names, e.g. org/*")
+	retryCmd.Flags().DurationVar(&RetryMaxAge, "max-age", 0, "Only retry packages uploaded within this long, e.g. 24h (0 for no limit)")
+	retryCmd.Flags().BoolVar(&RetryRebuild, "rebuild", false, "Rebuild the packages from git and upload them again, rather than asking Cloudsmith to resync them")
 	rootCmd.AddCommand(retryCmd)
 }
 
@@ -13,8 +28,67 @@ var retryCmd = &cobra.Command{
 	Use:   "retry",
 	Short: "Retry's packages that failed to sync",
 	Run: func(cmd *cobra.Command, args []string) {
-
+		backend := git.NewBackend(config)
 		client := cloudsmith.NewClient(config.ApiKey)
-		client.RetryFailed(config.Owner, config.TargetRepository)
+		client.Progress = logUploadProgress
+
+		syncer := sync.NewSyncer(config, client, backend)
+		syncer.DryRun = dryRun
+		syncer.Logf = logf
+
+		if RetryRebuild {
+			// Rebuilding publishes refs like run does, which needs the same
+			// setup
+			err := discoverRepositories(backend)
+			exitOnError(err)
+
+			err = client.LoadPackages(config.Owner, config.TargetRepository)
+			exitOnError(err)
+
+			artifactCache, err := cache.Open(config.GetArtifactIndexPath())
+			exitOnError(err)
+
+			syncer.Cache = artifactCache
+			syncer.IgnoreCache = true
+		}
+
+		store, err := state.Open(config.GetStatePath())
+		exitOnError(err)
+
+		syncer.State = store
+
+		results, err := syncer.RetryFailed(sync.RetryOptions{
+			Names:   RetryNames,
+			MaxAge:  RetryMaxAge,
+			Rebuild: RetryRebuild,
+		})
+		exitOnError(err)
+
+		fmt.Println()
+
+		err = report.WriteTable(os.Stdout, results)
+		exitOnError(err)
+
+		fmt.Println()
+
+		if dryRun {
+			fmt.Printf(
+				"%d would be resynced, %d would be rebuilt, %d failed\n",
+				report.Count(results, report.WouldResync),
+				report.Count(results, report.WouldPublish),
+				report.Count(results, report.Failed),
+			)
+		} else {
+			fmt.Printf(
+				"%d resynced, %d rebuilt, %d failed\n",
+				report.Count(results, report.Resynced),
+				report.Count(results, report.Published),
+				report.Count(results, report.Failed),
+			)
+		}
+
+		if report.HasFailures(results) {
+			os.Exit(1)
+		}
 	},
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"os"
+	sync2 "sync"
 )
 
 var cfgFile string
@@ -35,7 +36,15 @@ func initConfig() {
 		os.Exit(1)
 	}
 
-	config = config2.NewConfigFromViper(workingDirectory)
+	var err error
+
+	config, err = config2.NewConfigFromViper(workingDirectory)
+
+	if err != nil {
+		fmt.Println("Invalid config:", err)
+		os.Exit(1)
+	}
+
 	config.EnsureDirsExist()
 }
 
@@ -58,3 +67,23 @@ func exitOnError(err error) {
 		os.Exit(1)
 	}
 }
+
+var outputMutex sync2.Mutex
+
+// logf prints a line without interleaving with output from other goroutines.
+func logf(format string, a ...interface{}) {
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+
+	fmt.Printf(format+"\n", a...)
+}
+
+// logUploadProgress logs how far along the uploads of large artifacts are,
+// smaller ones are done before it would be of any use.
+func logUploadProgress(filename string, sent, total int64) {
+	if total < 10<<20 {
+		return
+	}
+
+	logf("Uploading %s - %d%% of %.1f MB", filename, sent*100/total, float64(total)/(1<<20))
+}
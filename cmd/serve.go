@@ -3,26 +3,40 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cache"
 	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
 	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/queue"
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	"github.com/Lavoaster/cloudsmith-sync/sync"
 	"github.com/Lavoaster/cloudsmith-sync/webhooks"
 	"github.com/gorilla/mux"
 	"github.com/spf13/cobra"
+	"gopkg.in/go-playground/webhooks.v5/bitbucket-server"
 	"gopkg.in/go-playground/webhooks.v5/github"
+	"gopkg.in/go-playground/webhooks.v5/gitlab"
 	"net/http"
 	"os"
 	"os/signal"
 	"time"
 )
 
+var Workers int
+
 func init() {
+	serveCmd.Flags().IntVarP(&Workers, "workers", "w", 2, "Number of background workers syncing queued refs")
 	rootCmd.AddCommand(serveCmd)
 }
 
 var serveCmd = &cobra.Command{
 	Use:   "serve",
-	Short: "Runs a server that listens for GitHub webhooks",
+	Short: "Runs a server that listens for GitHub, GitLab and Bitbucket Server webhooks",
 	Run: func(cmd *cobra.Command, args []string) {
+		backend := git.NewBackend(config)
+
+		err := discoverRepositories(backend)
+		exitOnError(err)
+
 		router := mux.NewRouter()
 
 		hook, err := github.New(github.Options.Secret(config.WebhookSecret))
@@ -30,12 +44,49 @@ var serveCmd = &cobra.Command{
 
 		webhooks.Hook = hook
 
+		gitlabHook, err := gitlab.New(gitlab.Options.Secret(config.GitlabWebhookSecret))
+		exitOnError(err)
+
+		webhooks.GitlabHook = gitlabHook
+
+		bitbucketHook, err := bitbucketserver.New(bitbucketserver.Options.Secret(config.BitbucketWebhookSecret))
+		exitOnError(err)
+
+		webhooks.BitbucketHook = bitbucketHook
+
 		router.HandleFunc("/webhooks/github", webhooks.HandleGithubWebhook).Methods("POST")
+		router.HandleFunc("/webhooks/gitlab", webhooks.HandleGitlabWebhook).Methods("POST")
+		router.HandleFunc("/webhooks/bitbucket", webhooks.HandleBitbucketWebhook).Methods("POST")
+		router.HandleFunc("/webhooks/generic", webhooks.HandleGenericWebhook).Methods("POST")
 
-		webhooks.Client = cloudsmith.NewClient(config.ApiKey)
+		artifactCache, err := cache.Open(config.GetArtifactIndexPath())
+		exitOnError(err)
+
+		store, err := state.Open(config.GetStatePath())
+		exitOnError(err)
+
+		client := cloudsmith.NewClient(config.ApiKey)
+		client.Progress = logUploadProgress
+
+		syncer := sync.NewSyncer(config, client, backend)
+		syncer.Cache = artifactCache
+		syncer.State = store
+		syncer.DryRun = dryRun
+		syncer.Logf = logf
+
+		webhooks.Syncer = syncer
 		webhooks.Config = config
+		webhooks.Logf = logf
 
-		git.Config = config
+		jobQueue, err := queue.New(config.GetQueuePath(), webhooks.ProcessJob)
+		exitOnError(err)
+
+		webhooks.Queue = jobQueue
+		jobQueue.Start(Workers)
+
+		if pending := jobQueue.Len(); pending > 0 {
+			fmt.Printf("Resuming %d queued jobs\n", pending)
+		}
 
 		srv := &http.Server{
 			Addr: config.Server,
@@ -72,6 +123,11 @@ var serveCmd = &cobra.Command{
 
 		srv.Shutdown(ctx)
 
+		// Let running jobs finish, anything still waiting is persisted and
+		// resumed on the next start.
+		fmt.Println("waiting for running jobs to finish")
+		jobQueue.Stop()
+
 		// Optionally, you could run srv.Shutdown in a goroutine and block on
 		// <-ctx.Done() if your application should wait for other services
 		// to finalize based on context cancellation.
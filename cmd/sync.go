@@ -1,23 +1,35 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cache"
 	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
-	"github.com/Lavoaster/cloudsmith-sync/composer"
-	config2 "github.com/Lavoaster/cloudsmith-sync/config"
 	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	"github.com/Lavoaster/cloudsmith-sync/sync"
 	"github.com/briandowns/spinner"
 	"github.com/spf13/cobra"
-	git2 "gopkg.in/src-d/go-git.v4"
+	"os"
 	"strconv"
-	"strings"
 	"time"
 )
 
 var Target string
+var Concurrency int
+var ReportFormat string
+var ReportFile string
+var Wait bool
+var Prune bool
 
 func init() {
 	runCmd.Flags().StringVarP(&Target, "target", "t", "both", "Target [tags, branches, both]")
+	runCmd.Flags().IntVarP(&Concurrency, "concurrency", "c", 1, "Number of repositories, and refs within them, to sync in parallel")
+	runCmd.Flags().StringVar(&ReportFormat, "report", "", "Write a report of the sync results [json, junit]")
+	runCmd.Flags().StringVar(&ReportFile, "report-file", "", "Report file location (defaults to report.json or report.xml)")
+	runCmd.Flags().BoolVar(&Wait, "wait", false, "Wait for Cloudsmith to process uploaded packages, up to pollTimeout")
+	runCmd.Flags().BoolVar(&Prune, "prune", false, "Delete packages whose branch or tag no longer exists once synced, following the prune rules in the config")
 	rootCmd.AddCommand(runCmd)
 }
 
@@ -25,15 +37,32 @@ var runCmd = &cobra.Command{
 	Use:   "run",
 	Short: "Performs a full sync on repositories",
 	Run: func(cmd *cobra.Command, args []string) {
+		if Target != sync.TargetTags && Target != sync.TargetBranches && Target != sync.TargetBoth {
+			exitOnError(errors.New("invalid target " + Target + ", expected one of tags, branches or both"))
+		}
+
+		if Concurrency < 1 {
+			exitOnError(errors.New("concurrency must be at least 1"))
+		}
+
+		if ReportFormat != "" && ReportFormat != "json" && ReportFormat != "junit" {
+			exitOnError(errors.New("invalid report format " + ReportFormat + ", expected one of json or junit"))
+		}
+
 		fmt.Println("Repository Sync")
 		fmt.Println("===============")
 		fmt.Println()
 
+		backend := git.NewBackend(config)
+
+		err := discoverRepositories(backend)
+		exitOnError(err)
+
 		totalRepositories := strconv.Itoa(len(config.Repositories))
 		fmt.Println("Syncing " + totalRepositories + " repositories")
 
 		client := cloudsmith.NewClient(config.ApiKey)
-		git.Config = config
+		client.Progress = logUploadProgress
 
 		fmt.Print("Loading existing packages...")
 
@@ -42,163 +71,91 @@ var runCmd = &cobra.Command{
 		s.FinalMSG = "Done\n\n"
 		s.Start()
 
-		err := client.LoadPackages(config.Owner, config.TargetRepository)
+		err = client.LoadPackages(config.Owner, config.TargetRepository)
 		exitOnError(err)
 
 		s.Stop()
 
-		for _, repoCfg := range config.Repositories {
-			// Repo Config
-			repoDir, err := git.GitUrlToDirectory(repoCfg.Url)
-			repoPath := config.GetRepoPath(repoDir)
-			exitOnError(err)
-
-			processLine := "Processing repository: " + repoCfg.Url
-			outer := strings.Repeat("=", len(processLine))
-
-			fmt.Println(outer)
-			fmt.Println(processLine)
-			fmt.Println(outer)
-			fmt.Println()
-
-			// Clone Repo
-			repo, err := git.CloneOrOpenAndUpdate(repoCfg.Url, repoPath)
-			exitOnError(err)
-
-			// Get Remote
-			remote, err := repo.Remote("origin")
-			exitOnError(err)
-
-			auth, err := git.GetAuth()
-			exitOnError(err)
+		artifactCache, err := cache.Open(config.GetArtifactIndexPath())
+		exitOnError(err)
 
-			refList, err := remote.List(&git2.ListOptions{Auth: auth})
-			exitOnError(err)
+		store, err := state.Open(config.GetStatePath())
+		exitOnError(err)
 
-			worktree, err := repo.Worktree()
-			exitOnError(err)
+		syncer := sync.NewSyncer(config, client, backend)
+		syncer.Cache = artifactCache
+		syncer.State = store
+		syncer.Target = Target
+		syncer.Concurrency = Concurrency
+		syncer.DryRun = dryRun
+		syncer.Wait = Wait
+		syncer.Logf = logf
 
-			for _, ref := range refList {
-				isBranch := strings.HasPrefix(ref.Name().String(), "refs/heads/")
-				isTag := strings.HasPrefix(ref.Name().String(), "refs/tags/")
+		results := syncer.SyncRepositories(config.Repositories)
 
-				if !isBranch && !isTag {
-					continue
-				}
+		if Prune {
+			fmt.Println()
+			fmt.Println("Pruning packages of deleted refs...")
 
-				// Tags
-				if isTag {
-					_, err := git.CheckoutTag(repo, worktree, ref)
+			results = append(results, syncer.PruneRepositories(config.Repositories)...)
+		}
 
-					if err != nil {
-						fmt.Printf("Skipping tag %v - %v\n", ref, err.Error())
-						continue
-					}
-				}
+		fmt.Println()
+		fmt.Println("Summary")
+		fmt.Println("=======")
+		fmt.Println()
 
-				// Branch
-				if isBranch {
-					_, err := git.CheckoutBranch(repo, worktree, ref)
+		err = report.WriteTable(os.Stdout, results)
+		exitOnError(err)
 
-					if err != nil {
-						fmt.Printf("Skipping branch %v - %v\n", ref, err.Error())
-						continue
-					}
-				}
+		published := fmt.Sprintf("%d published", report.Count(results, report.Published))
+		deleted := fmt.Sprintf("%d deleted", report.Count(results, report.Deleted))
 
-				processPackage(client, &repoCfg, repoPath, ref.Name().Short(), isBranch, ref.Hash().String())
+		if dryRun {
+			published = fmt.Sprintf("%d would be published", report.Count(results, report.WouldPublish))
+			deleted = fmt.Sprintf("%d would be deleted", report.Count(results, report.WouldDelete))
+		}
 
-				worktree.Reset(&git2.ResetOptions{
-					Mode: git2.HardReset,
-				})
-			}
+		fmt.Println()
+		fmt.Printf(
+			"%s, %d already existed, %s, %d skipped, %d failed\n",
+			published,
+			report.Count(results, report.AlreadyExists),
+			deleted,
+			report.Count(results, report.Skipped),
+			report.Count(results, report.Failed),
+		)
+
+		if ReportFormat != "" {
+			err = writeReport(ReportFormat, ReportFile, results)
+			exitOnError(err)
+		}
 
-			fmt.Println()
+		if report.HasFailures(results) {
+			os.Exit(1)
 		}
 	},
 }
 
-func processPackage(
-	client *cloudsmith.Client,
-	repoCfg *config2.Repository,
-	repoPath, branchOrTagName string,
-	isBranch bool,
-	commitRef string,
-) {
-	composerData, err := composer.LoadFile(repoPath)
-	exitOnError(err)
-
-	packageName := composerData["name"].(string)
-
-	version, normalisedVersion, err := composer.DeriveVersion(branchOrTagName, isBranch)
-
-	if err != nil {
-		fmt.Printf("Skipping %s@%s due to %s...\n", packageName, branchOrTagName, err)
-		return
-	}
-
-	fmt.Printf("Processing %s@%s...", packageName, version)
+func writeReport(format, path string, results []report.Result) error {
+	if path == "" {
+		path = "report.json"
 
-	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
-	s.Prefix = " "
-	s.Start()
-
-	if client.IsAwareOfPackage(packageName, version) {
-		if isBranch {
-			client.DeletePackageIfExists(config.Owner, config.TargetRepository, packageName, version)
-
-			s.Suffix = " Waiting for package to be deleted"
-
-			for {
-				exists, err := client.RemoteCheckPackageExists(config.Owner, config.TargetRepository, packageName, version)
-				exitOnError(err)
-
-				if !exists {
-					s.Suffix = ""
-					break
-				}
-
-				time.Sleep(2 * time.Second)
-			}
-		} else {
-			s.FinalMSG = "already exists\n"
-			s.Stop()
-			return
+		if format == "junit" {
+			path = "report.xml"
 		}
 	}
 
-	var source *composer.Source
+	file, err := os.Create(path)
 
-	if repoCfg.PublishSource {
-		source = &composer.Source{
-			Url:       repoCfg.Url,
-			Type:      "git",
-			Reference: commitRef,
-		}
+	if err != nil {
+		return err
 	}
+	defer file.Close()
 
-	// Mutate composer.json file
-	err = composer.MutateComposerFile(repoPath, version, normalisedVersion, source)
-	exitOnError(err)
-
-	// Extract Info from the composer file
-	packageNameParts := strings.Split(packageName, "/")
-	namespace := packageNameParts[0]
-	name := packageNameParts[1]
-
-	artifactName := fmt.Sprintf("%v-%v-%v.zip", namespace, name, commitRef)
-	artifactPath := config.GetArtifactPath(artifactName)
-
-	// Create archive file
-	err = git.CreateArtifactFromRepository(repoPath, artifactPath)
-	exitOnError(err)
-
-	if !dryRun {
-		// Upload archive to cloudsmith
-		_, err = client.UploadComposerPackage(config.Owner, config.TargetRepository, artifactPath)
-		exitOnError(err)
+	if format == "junit" {
+		return report.WriteJUnit(file, results)
 	}
 
-	s.FinalMSG = "done\n"
-	s.Stop()
+	return report.WriteJSON(file, results)
 }
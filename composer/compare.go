@@ -0,0 +1,144 @@
+package composer
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Stabilities ordered from least to most stable, matching the order used by
+// composer when comparing pre-release versions.
+var stabilityOrder = map[string]int{
+	"dev":   0,
+	"alpha": 1,
+	"beta":  2,
+	"RC":    3,
+	"":      4,
+	"patch": 5,
+}
+
+// CompareVersions compares two normalised versions (as returned by
+// NormaliseVersion) and returns -1 if a < b, 0 if they are equal and 1 if a > b.
+func CompareVersions(a, b string) int {
+	aNumbers, aStability, aStabilityNumbers, aDev := splitNormalisedVersion(a)
+	bNumbers, bStability, bStabilityNumbers, bDev := splitNormalisedVersion(b)
+
+	if r := compareNumbers(aNumbers, bNumbers); r != 0 {
+		return r
+	}
+
+	if r := compareInts(stabilityOrder[aStability], stabilityOrder[bStability]); r != 0 {
+		return r
+	}
+
+	if r := compareNumbers(aStabilityNumbers, bStabilityNumbers); r != 0 {
+		return r
+	}
+
+	if aDev == bDev {
+		return 0
+	}
+
+	if aDev {
+		return -1
+	}
+
+	return 1
+}
+
+// IsPreRelease reports whether a normalised version is an alpha, beta or RC.
+func IsPreRelease(version string) bool {
+	_, stability, _, _ := splitNormalisedVersion(version)
+
+	return stability == "alpha" || stability == "beta" || stability == "RC"
+}
+
+// MinorLine returns the major.minor version line a normalised version belongs
+// to, e.g. 1.2 for 1.2.3.0-beta1.
+func MinorLine(version string) string {
+	numbers, _, _, _ := splitNormalisedVersion(version)
+
+	for len(numbers) < 2 {
+		numbers = append(numbers, 0)
+	}
+
+	return fmt.Sprintf("%d.%d", numbers[0], numbers[1])
+}
+
+func splitNormalisedVersion(version string) (numbers []int, stability string, stabilityNumbers []int, dev bool) {
+	parts := strings.SplitN(version, "-", 2)
+	numbers = parseNumbers(parts[0])
+
+	if len(parts) == 1 {
+		return
+	}
+
+	modifier := parts[1]
+
+	if strings.HasSuffix(modifier, "dev") {
+		dev = true
+		modifier = strings.TrimSuffix(strings.TrimSuffix(modifier, "dev"), "-")
+	}
+
+	exp := regexp.MustCompile(`^(alpha|beta|RC|patch)(.*)$`)
+
+	if r := exp.FindStringSubmatch(modifier); len(r) > 0 {
+		stability = r[1]
+		stabilityNumbers = parseNumbers(r[2])
+	} else if dev {
+		// a plain "-dev" suffix sits below every other stability
+		stability = "dev"
+		dev = false
+	}
+
+	return
+}
+
+func parseNumbers(s string) []int {
+	var numbers []int
+
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == '.' || r == '-' }) {
+		n, err := strconv.Atoi(part)
+
+		if err != nil {
+			continue
+		}
+
+		numbers = append(numbers, n)
+	}
+
+	return numbers
+}
+
+func compareNumbers(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+
+		if i < len(a) {
+			x = a[i]
+		}
+
+		if i < len(b) {
+			y = b[i]
+		}
+
+		if r := compareInts(x, y); r != 0 {
+			return r
+		}
+	}
+
+	return 0
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	}
+
+	if a > b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,61 @@
+package composer_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"testing"
+)
+
+var versionComparisons = []struct {
+	a        string
+	b        string
+	expected int
+}{
+	{"1.0.0.0", "1.0.0.0", 0},
+	{"1.0.0.0", "1.0.1.0", -1},
+	{"2.0.0.0", "1.9.9.0", 1},
+	{"1.10.0.0", "1.9.0.0", 1},
+	{"1.0.0.0-beta1", "1.0.0.0", -1},
+	{"1.0.0.0-alpha2", "1.0.0.0-beta1", -1},
+	{"1.0.0.0-RC1", "1.0.0.0-beta5", 1},
+	{"1.0.0.0-beta2", "1.0.0.0-beta10", -1},
+	{"1.0.0.0-patch1", "1.0.0.0", 1},
+	{"1.0.0.0-dev", "1.0.0.0-alpha1", -1},
+	{"1.0.0.0-RC1-dev", "1.0.0.0-RC1", -1},
+	{"2010.01.02", "2010.01.03", -1},
+}
+
+func TestCompareVersions(t *testing.T) {
+	for _, test := range versionComparisons {
+		actual := composer.CompareVersions(test.a, test.b)
+
+		if actual != test.expected {
+			t.Errorf("[!] CompareVersions(%s, %s) = %v; want %v", test.a, test.b, actual, test.expected)
+		}
+	}
+}
+
+var preReleases = []struct {
+	version    string
+	preRelease bool
+	minorLine  string
+}{
+	{"1.2.3.0", false, "1.2"},
+	{"1.2.0.0-beta1", true, "1.2"},
+	{"2.0.0.0-RC2", true, "2.0"},
+	{"2.1.0.0-alpha", true, "2.1"},
+	{"1.0.0.0-patch1", false, "1.0"},
+	{"1.0.0.0-dev", false, "1.0"},
+	{"3", false, "3.0"},
+}
+
+func TestIsPreRelease(t *testing.T) {
+	for _, test := range preReleases {
+		if actual := composer.IsPreRelease(test.version); actual != test.preRelease {
+			t.Errorf("[!] IsPreRelease(%s) = %v; want %v", test.version, actual, test.preRelease)
+		}
+
+		if actual := composer.MinorLine(test.version); actual != test.minorLine {
+			t.Errorf("[!] MinorLine(%s) = %v; want %v", test.version, actual, test.minorLine)
+		}
+	}
+}
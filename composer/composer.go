@@ -1,9 +1,9 @@
 package composer
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
-	"os"
 	"regexp"
 	"strings"
 )
@@ -60,6 +60,10 @@ func LoadFile(path string) (file ComposerFile, error error) {
 		return nil, err
 	}
 
+	return Parse(rawComposerFile)
+}
+
+func Parse(rawComposerFile []byte) (file ComposerFile, error error) {
 	error = json.Unmarshal(rawComposerFile, &file)
 
 	return
@@ -72,6 +76,18 @@ func MutateComposerFile(path, version, normalizedVersion string, source *Source)
 		return err
 	}
 
+	contents, err := MutateComposerData(data, version, normalizedVersion, source)
+
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path+"/composer.json", contents, 0644)
+}
+
+// MutateComposerData sets the version and source of a composer file, returning
+// it encoded as JSON.
+func MutateComposerData(data ComposerFile, version, normalizedVersion string, source *Source) ([]byte, error) {
 	data["version"] = version
 	data["version_normalized"] = normalizedVersion
 
@@ -79,18 +95,35 @@ func MutateComposerFile(path, version, normalizedVersion string, source *Source)
 		data["source"] = source
 	}
 
-	// Truncate on open, and in write mode only
-	file, err := os.OpenFile(path+"/composer.json", os.O_TRUNC|os.O_WRONLY, 0644)
-
-	if err != nil {
-		return err
-	}
-	defer file.Close()
+	var buffer bytes.Buffer
 
 	// Required to prevent goland from escaping "<", ">", and "&".
-	enc := json.NewEncoder(file)
+	enc := json.NewEncoder(&buffer)
 	enc.SetEscapeHTML(false)
 	enc.SetIndent("", "    ")
 
-	return enc.Encode(&data)
+	err := enc.Encode(&data)
+
+	return buffer.Bytes(), err
+}
+
+// ArchiveExcludes returns the archive.exclude patterns of the composer file.
+func (file ComposerFile) ArchiveExcludes() []string {
+	archive, ok := file["archive"].(map[string]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	rawExcludes, _ := archive["exclude"].([]interface{})
+
+	var excludes []string
+
+	for _, exclude := range rawExcludes {
+		if pattern, ok := exclude.(string); ok {
+			excludes = append(excludes, pattern)
+		}
+	}
+
+	return excludes
 }
@@ -2,16 +2,85 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"github.com/spf13/viper"
 	"os"
 	"strings"
+	"time"
 )
 
 type Repository struct {
 	Url           string
 	PublishSource bool
+	Branches      RefFilter
+	Tags          RefFilter
+	MinTagVersion string
+	// ArchiveFormat of the published dists, zip (default), tar or tar.gz
+	ArchiveFormat string
+	// Auth overrides how the repository is cloned and fetched, when nil
+	// ssh urls use the global ssh key, https GitHub urls use the GitHub App
+	// when one is configured and other https urls are fetched anonymously.
+	Auth *Auth
+	// Retention, when set, limits which branches and tags are published and
+	// is applied to existing packages by the retention command.
+	Retention *RetentionRules
 }
 
+const (
+	ArchiveZip   = "zip"
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+)
+
+const (
+	AuthSshKey    = "ssh-key"
+	AuthSshAgent  = "ssh-agent"
+	AuthBasic     = "basic"
+	AuthToken     = "token"
+	AuthGithubApp = "github-app"
+	AuthNone      = "none"
+)
+
+// Auth holds the credentials for a git remote. Password, Token and
+// SshKeyPassphrase can reference env vars or files, see ResolveSecret.
+type Auth struct {
+	Type             string
+	Username         string
+	Password         string
+	Token            string
+	SshKey           string
+	SshKeyPassphrase string
+}
+
+// GithubApp is used to clone and fetch https repositories as a GitHub App
+// installation. PrivateKey can reference an env var or file, see
+// ResolveSecret.
+type GithubApp struct {
+	AppId          int64
+	InstallationId int64
+	PrivateKey     string
+	BaseUrl        string
+}
+
+const (
+	// BuildModeWorktree checks every ref out and zips the working tree
+	BuildModeWorktree = "worktree"
+	// BuildModeTree zips the committed tree straight from the git objects
+	BuildModeTree = "tree"
+)
+
+const (
+	// ReplaceRepublish uploads a branches new package over the old one, which
+	// stays available until the new one has been processed
+	ReplaceRepublish = "republish"
+	// ReplaceDelete deletes a branches old package before uploading the new one
+	ReplaceDelete = "delete"
+)
+
+// DefaultPollTimeout is how long to wait for Cloudsmith to process a change
+// when pollTimeout isn't configured.
+const DefaultPollTimeout = 5 * time.Minute
+
 type Config struct {
 	ApiKey           string
 	DataDir          string
@@ -20,8 +89,20 @@ type Config struct {
 	SshKey           string
 	SshKeyPassphrase string
 	Repositories     []Repository
+	Discovery        []DiscoverySource
 	Server           string
 	WebhookSecret    string
+	WebhookToken     string
+	GithubApp        *GithubApp
+	BuildMode        string
+	ReplaceStrategy  string
+	// PollTimeout bounds how long to wait for Cloudsmith to process a change
+	PollTimeout time.Duration
+	Prune       PruneRules
+
+	// Per provider webhook secrets, these default to WebhookSecret
+	GitlabWebhookSecret    string
+	BitbucketWebhookSecret string
 }
 
 func (config *Config) EnsureDirsExist() {
@@ -56,30 +137,100 @@ func (config *Config) GetArtifactPath(artifact string) string {
 	return config.DataDir + "/artifacts/" + artifact
 }
 
-func NewConfigFromViper(workingDirectory string) *Config {
+func (config *Config) GetArtifactIndexPath() string {
+	return config.DataDir + "/artifacts/index.json"
+}
+
+func (config *Config) GetStatePath() string {
+	return config.DataDir + "/state.json"
+}
+
+func (config *Config) GetQueuePath() string {
+	return config.DataDir + "/queue.json"
+}
+
+func NewConfigFromViper(workingDirectory string) (*Config, error) {
 	var repositories []Repository
 
 	dataDir := viper.GetString("dataDir")
 	dataDir = strings.Replace(dataDir, "${cwd}", workingDirectory, 1)
 
-	for _, repo := range viper.Get("repositories").([]interface{}) {
-		cfg := repo.(map[interface{}]interface{})
+	rawRepositories, _ := viper.Get("repositories").([]interface{})
 
-		var url string
-		var publishSource bool
+	for _, rawRepo := range rawRepositories {
+		repo := parseRepository(rawRepo.(map[interface{}]interface{}))
 
-		if cfg["publishSource"] != nil {
-			publishSource = cfg["publishSource"].(bool)
+		if err := validateArchiveFormat(repo.ArchiveFormat); err != nil {
+			return nil, fmt.Errorf("repository %s: %v", repo.Url, err)
 		}
 
-		if cfg["url"] != nil {
-			url = cfg["url"].(string)
+		repositories = append(repositories, repo)
+	}
+
+	var discovery []DiscoverySource
+
+	rawDiscovery, _ := viper.Get("discovery").([]interface{})
+
+	for _, rawSource := range rawDiscovery {
+		source := parseDiscoverySource(rawSource.(map[interface{}]interface{}))
+
+		if err := validateArchiveFormat(source.Defaults.ArchiveFormat); err != nil {
+			return nil, fmt.Errorf("discovery %s %s: %v", source.Provider, source.Owner, err)
 		}
 
-		repositories = append(repositories, Repository{
-			Url:           url,
-			PublishSource: publishSource,
-		})
+		for _, nameDefaults := range source.NameDefaults {
+			if err := validateArchiveFormat(nameDefaults.Defaults.ArchiveFormat); err != nil {
+				return nil, fmt.Errorf("discovery %s %s %v: %v", source.Provider, source.Owner, nameDefaults.Names, err)
+			}
+		}
+
+		discovery = append(discovery, source)
+	}
+
+	webhookSecret := viper.GetString("webhookSecret")
+	gitlabWebhookSecret := viper.GetString("gitlabWebhookSecret")
+	bitbucketWebhookSecret := viper.GetString("bitbucketWebhookSecret")
+
+	if gitlabWebhookSecret == "" {
+		gitlabWebhookSecret = webhookSecret
+	}
+
+	if bitbucketWebhookSecret == "" {
+		bitbucketWebhookSecret = webhookSecret
+	}
+
+	buildMode := viper.GetString("buildMode")
+
+	if buildMode == "" {
+		buildMode = BuildModeWorktree
+	}
+
+	replaceStrategy := viper.GetString("replaceStrategy")
+
+	if replaceStrategy == "" {
+		replaceStrategy = ReplaceRepublish
+	}
+
+	pollTimeout := viper.GetDuration("pollTimeout")
+
+	if pollTimeout == 0 {
+		pollTimeout = DefaultPollTimeout
+	}
+
+	prune := PruneRules{
+		Keep: parseStringList(viper.Get("prune.keep")),
+		Tags: viper.GetBool("prune.tags"),
+	}
+
+	var githubApp *GithubApp
+
+	if viper.IsSet("githubApp") {
+		githubApp = &GithubApp{
+			AppId:          viper.GetInt64("githubApp.appId"),
+			InstallationId: viper.GetInt64("githubApp.installationId"),
+			PrivateKey:     viper.GetString("githubApp.privateKey"),
+			BaseUrl:        viper.GetString("githubApp.baseUrl"),
+		}
 	}
 
 	return &Config{
@@ -90,7 +241,184 @@ func NewConfigFromViper(workingDirectory string) *Config {
 		SshKey:           viper.GetString("sshKey"),
 		SshKeyPassphrase: viper.GetString("sshKeyPassphrase"),
 		Repositories:     repositories,
+		Discovery:        discovery,
 		Server:           viper.GetString("server"),
-		WebhookSecret:    viper.GetString("webhookSecret"),
+		WebhookSecret:    webhookSecret,
+		WebhookToken:     viper.GetString("webhookToken"),
+		GithubApp:        githubApp,
+		BuildMode:        buildMode,
+		ReplaceStrategy:  replaceStrategy,
+		PollTimeout:      pollTimeout,
+		Prune:            prune,
+
+		GitlabWebhookSecret:    gitlabWebhookSecret,
+		BitbucketWebhookSecret: bitbucketWebhookSecret,
+	}, nil
+}
+
+func validateArchiveFormat(archiveFormat string) error {
+	switch archiveFormat {
+	case ArchiveZip, ArchiveTar, ArchiveTarGz:
+		return nil
+	}
+
+	return fmt.Errorf("unsupported archive format %q, it can be zip, tar or tar.gz", archiveFormat)
+}
+
+func parseRepository(cfg map[interface{}]interface{}) Repository {
+	var url string
+	var publishSource bool
+	var minTagVersion string
+
+	archiveFormat := ArchiveZip
+
+	if cfg["publishSource"] != nil {
+		publishSource = cfg["publishSource"].(bool)
+	}
+
+	if cfg["url"] != nil {
+		url = cfg["url"].(string)
+	}
+
+	if cfg["minTagVersion"] != nil {
+		minTagVersion = fmt.Sprintf("%v", cfg["minTagVersion"])
+	}
+
+	if cfg["archiveFormat"] != nil {
+		archiveFormat = cfg["archiveFormat"].(string)
+	}
+
+	return Repository{
+		Url:           url,
+		PublishSource: publishSource,
+		Branches:      parseRefFilter(cfg["branches"]),
+		Tags:          parseRefFilter(cfg["tags"]),
+		MinTagVersion: minTagVersion,
+		ArchiveFormat: archiveFormat,
+		Auth:          parseAuth(cfg["auth"]),
+		Retention:     parseRetentionRules(cfg["retention"]),
+	}
+}
+
+func parseDiscoverySource(cfg map[interface{}]interface{}) DiscoverySource {
+	getString := func(key string) string {
+		if cfg[key] == nil {
+			return ""
+		}
+
+		return fmt.Sprintf("%v", cfg[key])
+	}
+
+	var requireComposer bool
+
+	if cfg["requireComposer"] != nil {
+		requireComposer = cfg["requireComposer"].(bool)
+	}
+
+	rawDefaults, ok := cfg["defaults"].(map[interface{}]interface{})
+
+	if !ok {
+		rawDefaults = make(map[interface{}]interface{})
+	}
+
+	defaults := parseRepository(rawDefaults)
+
+	// The defaults of name patterns are merged over the ones of the source,
+	// so they only have to list what's different
+	var nameDefaults []NameDefaults
+
+	rawNameDefaults, _ := cfg["nameDefaults"].([]interface{})
+
+	for _, rawEntry := range rawNameDefaults {
+		entry, ok := rawEntry.(map[interface{}]interface{})
+
+		if !ok {
+			continue
+		}
+
+		merged := make(map[interface{}]interface{})
+
+		for key, value := range rawDefaults {
+			merged[key] = value
+		}
+
+		if overrides, ok := entry["defaults"].(map[interface{}]interface{}); ok {
+			for key, value := range overrides {
+				merged[key] = value
+			}
+		}
+
+		nameDefaults = append(nameDefaults, NameDefaults{
+			Names:    parseStringList(entry["names"]),
+			Defaults: parseRepository(merged),
+		})
+	}
+
+	return DiscoverySource{
+		Provider:        getString("provider"),
+		Owner:           getString("owner"),
+		BaseUrl:         getString("baseUrl"),
+		Token:           getString("token"),
+		Protocol:        getString("protocol"),
+		Topics:          parseStringList(cfg["topics"]),
+		Names:           parseRefFilter(cfg["names"]),
+		RequireComposer: requireComposer,
+		Defaults:        defaults,
+		NameDefaults:    nameDefaults,
+	}
+}
+
+func parseRefFilter(raw interface{}) RefFilter {
+	var filter RefFilter
+
+	cfg, ok := raw.(map[interface{}]interface{})
+
+	if !ok {
+		return filter
+	}
+
+	filter.Include = parseStringList(cfg["include"])
+	filter.Exclude = parseStringList(cfg["exclude"])
+
+	return filter
+}
+
+func parseStringList(raw interface{}) []string {
+	var list []string
+
+	switch value := raw.(type) {
+	case string:
+		list = append(list, value)
+	case []interface{}:
+		for _, item := range value {
+			list = append(list, fmt.Sprintf("%v", item))
+		}
+	}
+
+	return list
+}
+
+func parseAuth(raw interface{}) *Auth {
+	cfg, ok := raw.(map[interface{}]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	getString := func(key string) string {
+		if cfg[key] == nil {
+			return ""
+		}
+
+		return fmt.Sprintf("%v", cfg[key])
+	}
+
+	return &Auth{
+		Type:             getString("type"),
+		Username:         getString("username"),
+		Password:         getString("password"),
+		Token:            getString("token"),
+		SshKey:           getString("sshKey"),
+		SshKeyPassphrase: getString("sshKeyPassphrase"),
 	}
 }
@@ -0,0 +1,82 @@
+package config_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/spf13/viper"
+	"testing"
+)
+
+var archiveFormatTests = []struct {
+	archiveFormat interface{}
+	valid         bool
+}{
+	{nil, true},
+	{"zip", true},
+	{"tar", true},
+	{"tar.gz", true},
+	{"tgz", false},
+	{"ZIP", false},
+}
+
+func TestNewConfigFromViperChecksArchiveFormats(t *testing.T) {
+	for _, test := range archiveFormatTests {
+		repo := map[interface{}]interface{}{"url": "git@github.com:org/repo.git"}
+
+		if test.archiveFormat != nil {
+			repo["archiveFormat"] = test.archiveFormat
+		}
+
+		viper.Reset()
+		viper.Set("repositories", []interface{}{repo})
+
+		_, err := config.NewConfigFromViper("/tmp")
+
+		if (err == nil) != test.valid {
+			t.Errorf("[!] archiveFormat %v gave %v; want valid %v", test.archiveFormat, err, test.valid)
+		}
+	}
+
+	viper.Reset()
+}
+
+func TestNewConfigFromViperMergesNameDefaults(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+
+	viper.Set("discovery", []interface{}{
+		map[interface{}]interface{}{
+			"provider": "github",
+			"owner":    "acme",
+			"defaults": map[interface{}]interface{}{
+				"publishSource": true,
+				"minTagVersion": "1.0.0",
+			},
+			"nameDefaults": []interface{}{
+				map[interface{}]interface{}{
+					"names":    []interface{}{"legacy-*"},
+					"defaults": map[interface{}]interface{}{"publishSource": false},
+				},
+			},
+		},
+	})
+
+	cfg, err := config.NewConfigFromViper("/tmp")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := cfg.Discovery[0]
+
+	legacy, err := source.DefaultsFor("legacy-api")
+
+	if err != nil || legacy.PublishSource || legacy.MinTagVersion != "1.0.0" {
+		t.Errorf("[!] DefaultsFor(legacy-api) = %+v, %v; want the source defaults without publishSource", legacy, err)
+	}
+
+	other, err := source.DefaultsFor("api")
+
+	if err != nil || !other.PublishSource || other.MinTagVersion != "1.0.0" {
+		t.Errorf("[!] DefaultsFor(api) = %+v, %v; want the source defaults", other, err)
+	}
+}
@@ -0,0 +1,63 @@
+package config
+
+const (
+	ProviderGithub = "github"
+	ProviderGitlab = "gitlab"
+)
+
+// DiscoverySource lists the repositories of a GitHub organization or GitLab
+// group, so they don't all have to be added to the config by hand.
+type DiscoverySource struct {
+	Provider string
+	// Owner is the GitHub organization or the GitLab group (including its
+	// parent groups, e.g. "group/subgroup").
+	Owner string
+	// BaseUrl points at the GitHub Enterprise API (e.g.
+	// https://github.example.com/api/v3) or a self-hosted GitLab instance.
+	BaseUrl string
+	// Token can reference an env var or file, see ResolveSecret. GitHub falls
+	// back to the GitHub App when there's no token.
+	Token string
+	// Protocol picks the discovered clone urls, "ssh" (default) or "https".
+	Protocol string
+	// Topics the repository needs at least one of, when there are any.
+	Topics []string
+	// Names filters repositories by name, with the same patterns ref filters
+	// use.
+	Names RefFilter
+	// RequireComposer skips repositories without a composer.json on their
+	// default branch.
+	RequireComposer bool
+	// Defaults are applied to every discovered repository, the url is
+	// replaced with the discovered one.
+	Defaults Repository
+	// NameDefaults are applied instead of Defaults to the repositories whose
+	// name matches their patterns, the first that matches is used.
+	NameDefaults []NameDefaults
+}
+
+// NameDefaults are the defaults of the discovered repositories whose name
+// matches any of the patterns, on top of the defaults of the source.
+type NameDefaults struct {
+	Names    []string
+	Defaults Repository
+}
+
+// DefaultsFor returns the defaults of a discovered repository by its name.
+func (source DiscoverySource) DefaultsFor(name string) (Repository, error) {
+	for _, nameDefaults := range source.NameDefaults {
+		filter := RefFilter{Include: nameDefaults.Names}
+
+		matched, err := filter.Matches(name)
+
+		if err != nil {
+			return Repository{}, err
+		}
+
+		if matched {
+			return nameDefaults.Defaults, nil
+		}
+	}
+
+	return source.Defaults, nil
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// RefFilter decides which branches or tags of a repository get synced.
+//
+// Patterns are globs (e.g. "release/*") unless wrapped in slashes, in which
+// case they're treated as regular expressions (e.g. "/^feature-\d+$/").
+type RefFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Matches reports whether the given short ref name passes the filter. An empty
+// include list includes everything, exclusions always win.
+func (filter RefFilter) Matches(name string) (bool, error) {
+	for _, pattern := range filter.Exclude {
+		matched, err := matchPattern(pattern, name)
+
+		if err != nil {
+			return false, err
+		}
+
+		if matched {
+			return false, nil
+		}
+	}
+
+	if len(filter.Include) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range filter.Include {
+		matched, err := matchPattern(pattern, name)
+
+		if err != nil {
+			return false, err
+		}
+
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ShouldSyncRef reports whether a branch or tag should be synced according to
+// the repositories filtering rules, along with a reason when it shouldn't.
+func (repo *Repository) ShouldSyncRef(name string, isBranch bool) (bool, string, error) {
+	filter := repo.Tags
+	kind := "tag"
+
+	if isBranch {
+		filter = repo.Branches
+		kind = "branch"
+	}
+
+	matched, err := filter.Matches(name)
+
+	if err != nil {
+		return false, "", err
+	}
+
+	if !matched {
+		return false, kind + " filtered out by configuration", nil
+	}
+
+	if isBranch || repo.MinTagVersion == "" {
+		return true, "", nil
+	}
+
+	minVersion, err := composer.NormaliseVersion(repo.MinTagVersion, "")
+
+	if err != nil {
+		return false, "", err
+	}
+
+	// Tags that don't look like versions are left for version derivation to
+	// reject, so they're reported consistently.
+	_, tagVersion, err := composer.DeriveVersion(name, false)
+
+	if err != nil {
+		return true, "", nil
+	}
+
+	if composer.CompareVersions(tagVersion, minVersion) < 0 {
+		return false, "tag is older than " + repo.MinTagVersion, nil
+	}
+
+	return true, "", nil
+}
+
+func matchPattern(pattern, name string) (bool, error) {
+	if len(pattern) > 1 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		return regexp.MatchString(pattern[1:len(pattern)-1], name)
+	}
+
+	return path.Match(pattern, name)
+}
@@ -0,0 +1,42 @@
+package config_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"testing"
+)
+
+var refFilterTests = []struct {
+	name     string
+	isBranch bool
+	expected bool
+}{
+	{"master", true, true},
+	{"release/1.2", true, true},
+	{"feature-login", true, false},
+	{"experiment", true, false},
+	{"2.1.0", false, true},
+	{"v1.9.0", false, false},
+	{"2.0.0-legacy", false, false},
+	{"not-a-version", false, true},
+}
+
+func TestShouldSyncRef(t *testing.T) {
+	repo := config.Repository{
+		Branches: config.RefFilter{
+			Include: []string{"master", "release/*", "/^feature-/"},
+			Exclude: []string{"/^feature-/"},
+		},
+		Tags: config.RefFilter{
+			Exclude: []string{"*-legacy"},
+		},
+		MinTagVersion: "2.0",
+	}
+
+	for _, test := range refFilterTests {
+		actual, _, err := repo.ShouldSyncRef(test.name, test.isBranch)
+
+		if err != nil || actual != test.expected {
+			t.Errorf("[!] ShouldSyncRef(%s, %v) = %v, %v; want %v", test.name, test.isBranch, actual, err, test.expected)
+		}
+	}
+}
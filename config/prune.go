@@ -0,0 +1,28 @@
+package config
+
+// PruneRules decide which orphaned packages, whose branch or tag no longer
+// exists, are left alone when pruning.
+type PruneRules struct {
+	// Keep are patterns, like the ones ref filters use, matched against the
+	// version and the name@version of a package. Matching packages are never
+	// pruned.
+	Keep []string
+	// Tags allows the packages of deleted tags to be pruned, by default only
+	// branches are.
+	Tags bool
+}
+
+// Keeps reports whether a package version is on the allowlist.
+func (rules PruneRules) Keeps(name, version string) (bool, error) {
+	for _, pattern := range rules.Keep {
+		for _, candidate := range []string{version, name + "@" + version} {
+			matched, err := matchPattern(pattern, candidate)
+
+			if err != nil || matched {
+				return matched, err
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,32 @@
+package config_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"testing"
+)
+
+var pruneKeepTests = []struct {
+	name     string
+	version  string
+	expected bool
+}{
+	{"org/package", "dev-legacy", true},
+	{"org/package", "dev-release/1.x", true},
+	{"org/package", "dev-feature", false},
+	{"org/pinned", "dev-feature", true},
+	{"org/other", "dev-feature", false},
+}
+
+func TestPruneRulesKeeps(t *testing.T) {
+	rules := config.PruneRules{
+		Keep: []string{"dev-legacy", "/^dev-release\\//", "org/pinned@*"},
+	}
+
+	for _, test := range pruneKeepTests {
+		actual, err := rules.Keeps(test.name, test.version)
+
+		if err != nil || actual != test.expected {
+			t.Errorf("[!] Keeps(%s, %s) = %v, %v; want %v", test.name, test.version, actual, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+// RetentionRules limit how long, and how many, packages of a repository are
+// kept. Zero values don't limit anything.
+type RetentionRules struct {
+	// BranchDays keeps the packages of branches updated within this many days.
+	BranchDays int
+	// PreReleasesPerMinor keeps this many of the newest alpha, beta and RC
+	// tags of every minor version line, e.g. 1.2.
+	PreReleasesPerMinor int
+	// KeepBranches are patterns, like the ones ref filters use, of branches
+	// that are kept however old they are.
+	KeepBranches []string
+}
+
+// KeepsBranch reports whether a branch is kept regardless of its age.
+func (rules RetentionRules) KeepsBranch(name string) (bool, error) {
+	for _, pattern := range rules.KeepBranches {
+		matched, err := matchPattern(pattern, name)
+
+		if err != nil || matched {
+			return matched, err
+		}
+	}
+
+	return false, nil
+}
+
+func parseRetentionRules(raw interface{}) *RetentionRules {
+	cfg, ok := raw.(map[interface{}]interface{})
+
+	if !ok {
+		return nil
+	}
+
+	getInt := func(key string) int {
+		value, _ := cfg[key].(int)
+
+		return value
+	}
+
+	return &RetentionRules{
+		BranchDays:          getInt("branchDays"),
+		PreReleasesPerMinor: getInt("preReleasesPerMinor"),
+		KeepBranches:        parseStringList(cfg["keepBranches"]),
+	}
+}
@@ -0,0 +1,30 @@
+package config_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"testing"
+)
+
+var keepsBranchTests = []struct {
+	branch   string
+	expected bool
+}{
+	{"master", true},
+	{"release/1.x", true},
+	{"hotfix/release/1.x", false},
+	{"feature/login", false},
+}
+
+func TestRetentionRulesKeepsBranch(t *testing.T) {
+	rules := config.RetentionRules{
+		KeepBranches: []string{"master", "/^release\\//"},
+	}
+
+	for _, test := range keepsBranchTests {
+		actual, err := rules.KeepsBranch(test.branch)
+
+		if err != nil || actual != test.expected {
+			t.Errorf("[!] KeepsBranch(%s) = %v, %v; want %v", test.branch, actual, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ResolveSecret resolves credentials from the config, so secrets don't have to
+// live in config.yaml. Values prefixed with "env:" are read from the named
+// environment variable and values prefixed with "file:" from the named file,
+// anything else is used as is.
+func ResolveSecret(value string) (string, error) {
+	if strings.HasPrefix(value, "env:") {
+		name := strings.TrimPrefix(value, "env:")
+		secret, ok := os.LookupEnv(name)
+
+		if !ok {
+			return "", &MissingSecretError{Source: "environment variable " + name}
+		}
+
+		return secret, nil
+	}
+
+	if strings.HasPrefix(value, "file:") {
+		path := strings.TrimPrefix(value, "file:")
+		secret, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(secret), "\r\n"), nil
+	}
+
+	return value, nil
+}
+
+type MissingSecretError struct {
+	Source string
+}
+
+func (e *MissingSecretError) Error() string {
+	return e.Source + " is not set"
+}
@@ -0,0 +1,41 @@
+package config_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	os.Setenv("CLOUDSMITH_SYNC_TEST_SECRET", "from-env")
+	defer os.Unsetenv("CLOUDSMITH_SYNC_TEST_SECRET")
+
+	file, err := ioutil.TempFile("", "secret")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("from-file\n")
+	file.Close()
+
+	tests := [][]string{
+		{"plain", "plain"},
+		{"env:CLOUDSMITH_SYNC_TEST_SECRET", "from-env"},
+		{"file:" + file.Name(), "from-file"},
+	}
+
+	for _, test := range tests {
+		actual, err := config.ResolveSecret(test[0])
+
+		if actual != test[1] || err != nil {
+			t.Errorf("[!] ResolveSecret(%s) = %v, %v; want %v", test[0], actual, err, test[1])
+		}
+	}
+
+	if _, err := config.ResolveSecret("env:CLOUDSMITH_SYNC_TEST_MISSING"); err == nil {
+		t.Error("[!] ResolveSecret(env:CLOUDSMITH_SYNC_TEST_MISSING) returned no error")
+	}
+}
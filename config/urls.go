@@ -0,0 +1,65 @@
+package config
+
+import (
+	"errors"
+	url2 "net/url"
+	"strings"
+)
+
+// NormalizeRepositoryUrl reduces ssh, scp-like and https git urls to
+// "host/path", so the different urls of a repository can be compared.
+func NormalizeRepositoryUrl(url string) string {
+	rawUrl := strings.TrimSpace(url)
+
+	// scp-like urls (git@github.com:org/repo.git) aren't parseable as is
+	if !strings.Contains(rawUrl, "://") {
+		if i := strings.Index(rawUrl, ":"); i >= 0 {
+			rawUrl = "ssh://" + rawUrl[:i] + "/" + rawUrl[i+1:]
+		}
+	}
+
+	urlInfo, err := url2.Parse(rawUrl)
+
+	if err != nil {
+		return strings.ToLower(url)
+	}
+
+	path := strings.Trim(urlInfo.Path, "/")
+	path = strings.TrimSuffix(path, ".git")
+
+	return strings.ToLower(urlInfo.Hostname() + "/" + path)
+}
+
+// FindRepository finds the configured repository matching any of the given
+// urls, regardless of whether they're ssh or https urls.
+func (config *Config) FindRepository(urls ...string) (Repository, error) {
+	for _, url := range urls {
+		if url == "" {
+			continue
+		}
+
+		normalized := NormalizeRepositoryUrl(url)
+
+		for _, repo := range config.Repositories {
+			if NormalizeRepositoryUrl(repo.Url) == normalized {
+				return repo, nil
+			}
+		}
+	}
+
+	return Repository{}, errors.New("repository not found")
+}
+
+// FindRepositoryByPath finds the configured repository whose url ends with the
+// given path (e.g. "project/repo"), for providers that don't send clone urls.
+func (config *Config) FindRepositoryByPath(path string) (Repository, error) {
+	suffix := "/" + strings.ToLower(strings.Trim(path, "/"))
+
+	for _, repo := range config.Repositories {
+		if strings.HasSuffix(NormalizeRepositoryUrl(repo.Url), suffix) {
+			return repo, nil
+		}
+	}
+
+	return Repository{}, errors.New("repository not found")
+}
@@ -0,0 +1,50 @@
+package config_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"testing"
+)
+
+var repositoryUrls = [][]string{
+	{"git@github.com:Org/Repo.git", "github.com/org/repo"},
+	{"https://github.com/org/repo.git", "github.com/org/repo"},
+	{"https://github.com/org/repo", "github.com/org/repo"},
+	{"ssh://git@gitlab.example.com:2222/group/sub/repo.git", "gitlab.example.com/group/sub/repo"},
+	{"https://gitlab.example.com/group/sub/repo.git", "gitlab.example.com/group/sub/repo"},
+	{"ssh://git@bitbucket.example.com:7999/proj/repo.git", "bitbucket.example.com/proj/repo"},
+}
+
+func TestNormalizeRepositoryUrl(t *testing.T) {
+	for _, test := range repositoryUrls {
+		actual := config.NormalizeRepositoryUrl(test[0])
+
+		if actual != test[1] {
+			t.Errorf("[!] NormalizeRepositoryUrl(%s) = %v; want %v", test[0], actual, test[1])
+		}
+	}
+}
+
+func TestFindRepository(t *testing.T) {
+	cfg := config.Config{
+		Repositories: []config.Repository{
+			{Url: "git@github.com:org/repo.git"},
+			{Url: "ssh://git@bitbucket.example.com:7999/proj/other.git"},
+		},
+	}
+
+	repo, err := cfg.FindRepository("", "https://github.com/org/repo.git")
+
+	if err != nil || repo.Url != "git@github.com:org/repo.git" {
+		t.Errorf("[!] FindRepository(https url) = %v, %v; want the ssh configured repository", repo.Url, err)
+	}
+
+	repo, err = cfg.FindRepositoryByPath("PROJ/other")
+
+	if err != nil || repo.Url != "ssh://git@bitbucket.example.com:7999/proj/other.git" {
+		t.Errorf("[!] FindRepositoryByPath(PROJ/other) = %v, %v; want the bitbucket repository", repo.Url, err)
+	}
+
+	if _, err := cfg.FindRepository("git@github.com:org/missing.git"); err == nil {
+		t.Error("[!] FindRepository(missing) returned no error")
+	}
+}
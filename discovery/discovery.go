@@ -0,0 +1,221 @@
+package discovery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/githubapp"
+	"io/ioutil"
+	"net/http"
+)
+
+// candidate is a repository returned by a providers API, before filtering.
+type candidate struct {
+	Id            string
+	Name          string
+	FullName      string
+	SSHURL        string
+	HTTPURL       string
+	DefaultBranch string
+	Topics        []string
+	Archived      bool
+}
+
+type provider interface {
+	listRepositories(source config.DiscoverySource) ([]candidate, error)
+	hasComposerFile(source config.DiscoverySource, repository candidate) (bool, error)
+}
+
+// Discoverer finds repositories through the GitHub and GitLab APIs.
+type Discoverer struct {
+	HTTPClient *http.Client
+	// GithubApp authenticates GitHub sources that have no token, it may be nil.
+	GithubApp *githubapp.App
+}
+
+type Error struct {
+	StatusCode int
+	Url        string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s responded with %d", e.Url, e.StatusCode)
+}
+
+func NewDiscoverer(app *githubapp.App) *Discoverer {
+	return &Discoverer{
+		HTTPClient: http.DefaultClient,
+		GithubApp:  app,
+	}
+}
+
+// Discover returns the statically configured repositories merged with the
+// ones found by the configs discovery sources. Repositories that are
+// configured statically keep their config, and the first source to find a
+// repository decides its defaults.
+func (d *Discoverer) Discover(cfg *config.Config) ([]config.Repository, error) {
+	repositories := append([]config.Repository{}, cfg.Repositories...)
+
+	known := make(map[string]bool)
+
+	for _, repo := range repositories {
+		known[config.NormalizeRepositoryUrl(repo.Url)] = true
+	}
+
+	for _, source := range cfg.Discovery {
+		discovered, err := d.DiscoverSource(source)
+
+		if err != nil {
+			return nil, fmt.Errorf("discovering %s repositories of %s: %v", source.Provider, source.Owner, err)
+		}
+
+		for _, repo := range discovered {
+			key := config.NormalizeRepositoryUrl(repo.Url)
+
+			if known[key] {
+				continue
+			}
+
+			known[key] = true
+			repositories = append(repositories, repo)
+		}
+	}
+
+	return repositories, nil
+}
+
+// DiscoverSource lists the repositories of a single source that pass its
+// filters, with the sources defaults applied.
+func (d *Discoverer) DiscoverSource(source config.DiscoverySource) ([]config.Repository, error) {
+	var p provider
+
+	switch source.Provider {
+	case config.ProviderGithub:
+		p = &github{d}
+	case config.ProviderGitlab:
+		p = &gitlab{d}
+	default:
+		return nil, errors.New("unknown discovery provider " + source.Provider)
+	}
+
+	candidates, err := p.listRepositories(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var repositories []config.Repository
+
+	for _, repository := range candidates {
+		matched, err := matches(source, repository)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !matched {
+			continue
+		}
+
+		if source.RequireComposer {
+			if repository.DefaultBranch == "" {
+				continue
+			}
+
+			hasComposer, err := p.hasComposerFile(source, repository)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !hasComposer {
+				continue
+			}
+		}
+
+		repo, err := source.DefaultsFor(repository.Name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		repo.Url = repository.SSHURL
+
+		if source.Protocol == "https" {
+			repo.Url = repository.HTTPURL
+		}
+
+		repositories = append(repositories, repo)
+	}
+
+	return repositories, nil
+}
+
+func matches(source config.DiscoverySource, repository candidate) (bool, error) {
+	if repository.Archived {
+		return false, nil
+	}
+
+	if len(source.Topics) > 0 && !hasAnyTopic(repository.Topics, source.Topics) {
+		return false, nil
+	}
+
+	return source.Names.Matches(repository.Name)
+}
+
+func hasAnyTopic(topics, wanted []string) bool {
+	for _, topic := range topics {
+		for _, want := range wanted {
+			if topic == want {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// get performs an authenticated GET request, decoding the JSON response into
+// target when it's not nil. Error responses are returned as *Error.
+func (d *Discoverer) get(url string, headers map[string]string, target interface{}) error {
+	req, err := http.NewRequest("GET", url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := d.HTTPClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &Error{StatusCode: resp.StatusCode, Url: url}
+	}
+
+	if target == nil {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(body, target)
+}
+
+// isNotFound reports whether err is a 404 response.
+func isNotFound(err error) bool {
+	apiError, ok := err.(*Error)
+
+	return ok && apiError.StatusCode == 404
+}
@@ -0,0 +1,233 @@
+package discovery_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/discovery"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func newGithubServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/orgs/acme/repos", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token secret" {
+			t.Errorf("[!] unexpected authorization header %q", r.Header.Get("Authorization"))
+		}
+
+		var repositories []map[string]interface{}
+
+		if r.URL.Query().Get("page") == "1" {
+			for _, name := range []string{"api-client", "website", "old-lib", "plain-lib"} {
+				repositories = append(repositories, map[string]interface{}{
+					"name":           name,
+					"full_name":      "acme/" + name,
+					"ssh_url":        "git@github.com:acme/" + name + ".git",
+					"clone_url":      "https://github.com/acme/" + name + ".git",
+					"default_branch": "main",
+					"topics":         []string{"php"},
+					"archived":       name == "old-lib",
+				})
+			}
+		}
+
+		json.NewEncoder(w).Encode(repositories)
+	})
+
+	mux.HandleFunc("/repos/acme/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/acme/api-client/contents/composer.json", "/repos/acme/website/contents/composer.json":
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(404)
+		}
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func newGitlabServer() *httptest.Server {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v4/groups/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/api/v4/groups/acme%2Fphp/projects" || r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			w.WriteHeader(404)
+			return
+		}
+
+		fmt.Fprint(w, `[
+			{"id": 1, "path": "lib-a", "path_with_namespace": "acme/php/lib-a", "ssh_url_to_repo": "git@gitlab.com:acme/php/lib-a.git", "http_url_to_repo": "https://gitlab.com/acme/php/lib-a.git", "default_branch": "master", "tag_list": ["composer"]},
+			{"id": 2, "path": "lib-b", "path_with_namespace": "acme/php/lib-b", "ssh_url_to_repo": "git@gitlab.com:acme/php/lib-b.git", "http_url_to_repo": "https://gitlab.com/acme/php/lib-b.git", "default_branch": "master", "topics": ["docs"]}
+		]`)
+	})
+
+	return httptest.NewServer(mux)
+}
+
+func urls(repositories []config.Repository) []string {
+	var result []string
+
+	for _, repo := range repositories {
+		result = append(result, repo.Url)
+	}
+
+	return result
+}
+
+func TestDiscoverGithub(t *testing.T) {
+	server := newGithubServer(t)
+	defer server.Close()
+
+	var discoverTests = []struct {
+		name     string
+		source   config.DiscoverySource
+		expected []string
+	}{
+		{
+			"all unarchived repositories",
+			config.DiscoverySource{},
+			[]string{"git@github.com:acme/api-client.git", "git@github.com:acme/website.git", "git@github.com:acme/plain-lib.git"},
+		},
+		{
+			"name filter",
+			config.DiscoverySource{Names: config.RefFilter{Exclude: []string{"web*"}}},
+			[]string{"git@github.com:acme/api-client.git", "git@github.com:acme/plain-lib.git"},
+		},
+		{
+			"missing topic",
+			config.DiscoverySource{Topics: []string{"go"}},
+			nil,
+		},
+		{
+			"composer.json required over https",
+			config.DiscoverySource{RequireComposer: true, Protocol: "https"},
+			[]string{"https://github.com/acme/api-client.git", "https://github.com/acme/website.git"},
+		},
+	}
+
+	for _, test := range discoverTests {
+		source := test.source
+		source.Provider = config.ProviderGithub
+		source.Owner = "acme"
+		source.BaseUrl = server.URL
+		source.Token = "secret"
+
+		repositories, err := discovery.NewDiscoverer(nil).DiscoverSource(source)
+
+		if err != nil {
+			t.Errorf("[!] %s: %v", test.name, err)
+			continue
+		}
+
+		if actual := urls(repositories); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("[!] %s: discovered %v; want %v", test.name, actual, test.expected)
+		}
+	}
+}
+
+func TestDiscoverGitlab(t *testing.T) {
+	server := newGitlabServer()
+	defer server.Close()
+
+	repositories, err := discovery.NewDiscoverer(nil).DiscoverSource(config.DiscoverySource{
+		Provider: config.ProviderGitlab,
+		Owner:    "acme/php",
+		BaseUrl:  server.URL,
+		Token:    "secret",
+		Topics:   []string{"composer"},
+		Defaults: config.Repository{PublishSource: true},
+	})
+
+	if err != nil {
+		t.Fatalf("[!] DiscoverSource() returned %v", err)
+	}
+
+	if len(repositories) != 1 || repositories[0].Url != "git@gitlab.com:acme/php/lib-a.git" {
+		t.Fatalf("[!] discovered %v; want only lib-a", urls(repositories))
+	}
+
+	if !repositories[0].PublishSource {
+		t.Errorf("[!] defaults weren't applied to the discovered repository")
+	}
+}
+
+func TestDiscoverAppliesNameDefaults(t *testing.T) {
+	server := newGithubServer(t)
+	defer server.Close()
+
+	repositories, err := discovery.NewDiscoverer(nil).DiscoverSource(config.DiscoverySource{
+		Provider: config.ProviderGithub,
+		Owner:    "acme",
+		BaseUrl:  server.URL,
+		Token:    "secret",
+		Defaults: config.Repository{PublishSource: true},
+		NameDefaults: []config.NameDefaults{
+			{Names: []string{"*-lib"}, Defaults: config.Repository{ArchiveFormat: config.ArchiveTarGz}},
+			{Names: []string{"api-*", "plain-*"}, Defaults: config.Repository{ArchiveFormat: config.ArchiveTar}},
+		},
+	})
+
+	if err != nil {
+		t.Fatalf("[!] DiscoverSource() returned %v", err)
+	}
+
+	expected := map[string]config.Repository{
+		"git@github.com:acme/api-client.git": {PublishSource: false, ArchiveFormat: config.ArchiveTar},
+		"git@github.com:acme/website.git":    {PublishSource: true},
+		"git@github.com:acme/plain-lib.git":  {PublishSource: false, ArchiveFormat: config.ArchiveTarGz},
+	}
+
+	if len(repositories) != len(expected) {
+		t.Fatalf("[!] discovered %v; want %d repositories", urls(repositories), len(expected))
+	}
+
+	for _, repo := range repositories {
+		want := expected[repo.Url]
+
+		if repo.PublishSource != want.PublishSource || repo.ArchiveFormat != want.ArchiveFormat {
+			t.Errorf("[!] %s was discovered with %+v; want %+v", repo.Url, repo, want)
+		}
+	}
+}
+
+func TestDiscoverMergesWithStaticRepositories(t *testing.T) {
+	server := newGithubServer(t)
+	defer server.Close()
+
+	cfg := &config.Config{
+		Repositories: []config.Repository{
+			{Url: "https://github.com/acme/website", PublishSource: true},
+		},
+		Discovery: []config.DiscoverySource{
+			{Provider: config.ProviderGithub, Owner: "acme", BaseUrl: server.URL, Token: "secret", RequireComposer: true},
+		},
+	}
+
+	repositories, err := discovery.NewDiscoverer(nil).Discover(cfg)
+
+	if err != nil {
+		t.Fatalf("[!] Discover() returned %v", err)
+	}
+
+	expected := []string{"https://github.com/acme/website", "git@github.com:acme/api-client.git"}
+
+	if actual := urls(repositories); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("[!] discovered %v; want %v", actual, expected)
+	}
+
+	if !repositories[0].PublishSource {
+		t.Errorf("[!] static repository config was replaced by a discovered one")
+	}
+}
+
+func TestDiscoverUnknownProvider(t *testing.T) {
+	_, err := discovery.NewDiscoverer(nil).DiscoverSource(config.DiscoverySource{Provider: "svn"})
+
+	if err == nil {
+		t.Errorf("[!] expected an error for an unknown provider")
+	}
+}
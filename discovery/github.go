@@ -0,0 +1,122 @@
+package discovery
+
+import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/githubapp"
+	url2 "net/url"
+	"strings"
+)
+
+type github struct {
+	*Discoverer
+}
+
+type githubRepository struct {
+	Name          string   `json:"name"`
+	FullName      string   `json:"full_name"`
+	SSHURL        string   `json:"ssh_url"`
+	CloneURL      string   `json:"clone_url"`
+	DefaultBranch string   `json:"default_branch"`
+	Topics        []string `json:"topics"`
+	Archived      bool     `json:"archived"`
+}
+
+func (g *github) listRepositories(source config.DiscoverySource) ([]candidate, error) {
+	headers, err := g.headers(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []candidate
+
+	for page := 1; ; page++ {
+		var repositories []githubRepository
+
+		url := fmt.Sprintf("%s/orgs/%s/repos?per_page=100&page=%d", baseUrl(source), url2.PathEscape(source.Owner), page)
+
+		if err := g.get(url, headers, &repositories); err != nil {
+			return nil, err
+		}
+
+		for _, repository := range repositories {
+			candidates = append(candidates, candidate{
+				Name:          repository.Name,
+				FullName:      repository.FullName,
+				SSHURL:        repository.SSHURL,
+				HTTPURL:       repository.CloneURL,
+				DefaultBranch: repository.DefaultBranch,
+				Topics:        repository.Topics,
+				Archived:      repository.Archived,
+			})
+		}
+
+		if len(repositories) < 100 {
+			break
+		}
+	}
+
+	return candidates, nil
+}
+
+func (g *github) hasComposerFile(source config.DiscoverySource, repository candidate) (bool, error) {
+	headers, err := g.headers(source)
+
+	if err != nil {
+		return false, err
+	}
+
+	url := fmt.Sprintf(
+		"%s/repos/%s/contents/composer.json?ref=%s",
+		baseUrl(source),
+		repository.FullName,
+		url2.QueryEscape(repository.DefaultBranch),
+	)
+
+	err = g.get(url, headers, nil)
+
+	if isNotFound(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (g *github) headers(source config.DiscoverySource) (map[string]string, error) {
+	headers := map[string]string{
+		"Accept": "application/vnd.github+json",
+	}
+
+	token, err := config.ResolveSecret(source.Token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token == "" && g.GithubApp != nil {
+		token, err = g.GithubApp.InstallationToken()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if token != "" {
+		headers["Authorization"] = "token " + token
+	}
+
+	return headers, nil
+}
+
+func baseUrl(source config.DiscoverySource) string {
+	if source.BaseUrl != "" {
+		return strings.TrimRight(source.BaseUrl, "/")
+	}
+
+	if source.Provider == config.ProviderGitlab {
+		return "https://gitlab.com"
+	}
+
+	return githubapp.DefaultBaseUrl
+}
@@ -0,0 +1,114 @@
+package discovery
+
+import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	url2 "net/url"
+	"strconv"
+)
+
+type gitlab struct {
+	*Discoverer
+}
+
+type gitlabProject struct {
+	Id                int64    `json:"id"`
+	Path              string   `json:"path"`
+	PathWithNamespace string   `json:"path_with_namespace"`
+	SSHURLToRepo      string   `json:"ssh_url_to_repo"`
+	HTTPURLToRepo     string   `json:"http_url_to_repo"`
+	DefaultBranch     string   `json:"default_branch"`
+	Topics            []string `json:"topics"`
+	TagList           []string `json:"tag_list"`
+	Archived          bool     `json:"archived"`
+}
+
+func (g *gitlab) listRepositories(source config.DiscoverySource) ([]candidate, error) {
+	headers, err := g.headers(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []candidate
+
+	for page := 1; ; page++ {
+		var projects []gitlabProject
+
+		url := fmt.Sprintf(
+			"%s/api/v4/groups/%s/projects?include_subgroups=true&archived=false&per_page=100&page=%d",
+			baseUrl(source),
+			url2.PathEscape(source.Owner),
+			page,
+		)
+
+		if err := g.get(url, headers, &projects); err != nil {
+			return nil, err
+		}
+
+		for _, project := range projects {
+			// Older GitLab versions call topics tags
+			topics := project.Topics
+
+			if len(topics) == 0 {
+				topics = project.TagList
+			}
+
+			candidates = append(candidates, candidate{
+				Id:            strconv.FormatInt(project.Id, 10),
+				Name:          project.Path,
+				FullName:      project.PathWithNamespace,
+				SSHURL:        project.SSHURLToRepo,
+				HTTPURL:       project.HTTPURLToRepo,
+				DefaultBranch: project.DefaultBranch,
+				Topics:        topics,
+				Archived:      project.Archived,
+			})
+		}
+
+		if len(projects) < 100 {
+			break
+		}
+	}
+
+	return candidates, nil
+}
+
+func (g *gitlab) hasComposerFile(source config.DiscoverySource, repository candidate) (bool, error) {
+	headers, err := g.headers(source)
+
+	if err != nil {
+		return false, err
+	}
+
+	url := fmt.Sprintf(
+		"%s/api/v4/projects/%s/repository/files/composer.json?ref=%s",
+		baseUrl(source),
+		repository.Id,
+		url2.QueryEscape(repository.DefaultBranch),
+	)
+
+	err = g.get(url, headers, nil)
+
+	if isNotFound(err) {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+func (g *gitlab) headers(source config.DiscoverySource) (map[string]string, error) {
+	headers := make(map[string]string)
+
+	token, err := config.ResolveSecret(source.Token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if token != "" {
+		headers["PRIVATE-TOKEN"] = token
+	}
+
+	return headers, nil
+}
@@ -0,0 +1,164 @@
+package git
+
+import (
+	"errors"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/githubapp"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	url2 "net/url"
+	"strings"
+)
+
+// GetAuth returns the auth method to use for a repositories remote. A nil auth
+// method without an error means the remote is accessed anonymously.
+func (b *Backend) GetAuth(repoCfg *config.Repository) (transport.AuthMethod, error) {
+	auth := repoCfg.Auth
+
+	if auth == nil {
+		auth = b.defaultAuth(repoCfg.Url)
+	}
+
+	switch auth.Type {
+	case config.AuthSshKey, "":
+		keyFile := auth.SshKey
+		passphrase := auth.SshKeyPassphrase
+
+		if keyFile == "" {
+			keyFile = b.Config.SshKey
+			passphrase = b.Config.SshKeyPassphrase
+		}
+
+		passphrase, err := config.ResolveSecret(passphrase)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return ssh.NewPublicKeysFromFile(usernameOrDefault(auth, "git"), keyFile, passphrase)
+
+	case config.AuthSshAgent:
+		return ssh.NewSSHAgentAuth(usernameOrDefault(auth, "git"))
+
+	case config.AuthBasic:
+		password, err := config.ResolveSecret(auth.Password)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &http.BasicAuth{Username: auth.Username, Password: password}, nil
+
+	case config.AuthToken:
+		token, err := config.ResolveSecret(auth.Token)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if token == "" {
+			return nil, errors.New("token auth requires a token")
+		}
+
+		// GitHub and GitLab accept tokens as the password with any username,
+		// deploy tokens need their own username though.
+		return &http.BasicAuth{Username: usernameOrDefault(auth, "git"), Password: token}, nil
+
+	case config.AuthGithubApp:
+		app, err := b.GithubApp()
+
+		if err != nil {
+			return nil, err
+		}
+
+		token, err := app.InstallationToken()
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &http.BasicAuth{Username: "x-access-token", Password: token}, nil
+
+	case config.AuthNone:
+		return nil, nil
+	}
+
+	return nil, errors.New("unknown auth type " + auth.Type)
+}
+
+// defaultAuth picks the auth for repositories without any configured: the
+// GitHub App for https GitHub urls when there is one, anonymous access for
+// other https urls and local repositories, and the global ssh key for
+// everything else.
+func (b *Backend) defaultAuth(url string) *config.Auth {
+	if strings.HasPrefix(url, "file://") {
+		return &config.Auth{Type: config.AuthNone}
+	}
+
+	if !isHttpUrl(url) {
+		return &config.Auth{Type: config.AuthSshKey}
+	}
+
+	if b.Config.GithubApp != nil && isGithubAppUrl(b.Config.GithubApp, url) {
+		return &config.Auth{Type: config.AuthGithubApp}
+	}
+
+	return &config.Auth{Type: config.AuthNone}
+}
+
+func usernameOrDefault(auth *config.Auth, username string) string {
+	if auth.Username != "" {
+		return auth.Username
+	}
+
+	return username
+}
+
+func isHttpUrl(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
+
+// GithubApp returns the GitHub App configured for cloning, it's created once
+// so installation tokens are shared between repositories.
+func (b *Backend) GithubApp() (*githubapp.App, error) {
+	b.githubAppOnce.Do(func() {
+		appCfg := b.Config.GithubApp
+
+		if appCfg == nil {
+			b.githubAppErr = errors.New("github app auth used without configuring githubApp")
+			return
+		}
+
+		privateKey, err := config.ResolveSecret(appCfg.PrivateKey)
+
+		if err != nil {
+			b.githubAppErr = err
+			return
+		}
+
+		b.githubApp, b.githubAppErr = githubapp.NewApp(appCfg.AppId, appCfg.InstallationId, []byte(privateKey), appCfg.BaseUrl)
+	})
+
+	return b.githubApp, b.githubAppErr
+}
+
+// isGithubAppUrl reports whether a repository is hosted on the GitHub the app
+// belongs to, either github.com or a GitHub Enterprise server.
+func isGithubAppUrl(appCfg *config.GithubApp, url string) bool {
+	host := "github.com"
+
+	if appCfg.BaseUrl != "" && appCfg.BaseUrl != githubapp.DefaultBaseUrl {
+		urlInfo, err := url2.Parse(appCfg.BaseUrl)
+
+		if err != nil {
+			return false
+		}
+
+		host = urlInfo.Hostname()
+	}
+
+	urlInfo, err := url2.Parse(url)
+
+	return err == nil && strings.EqualFold(urlInfo.Hostname(), host)
+}
@@ -2,50 +2,123 @@ package git
 
 import (
 	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/githubapp"
 	"gopkg.in/src-d/go-git.v4"
 	config2 "gopkg.in/src-d/go-git.v4/config"
 	"gopkg.in/src-d/go-git.v4/plumbing"
-	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
 	"os"
+	"sync"
 )
 
-var Config *config.Config
+// Backend performs git operations against remotes using the credentials
+// from the config.
+type Backend struct {
+	Config *config.Config
+
+	githubAppOnce sync.Once
+	githubApp     *githubapp.App
+	githubAppErr  error
+}
+
+func NewBackend(cfg *config.Config) *Backend {
+	return &Backend{Config: cfg}
+}
+
+func (b *Backend) CloneOrOpenAndUpdate(repoCfg *config.Repository, path string) (*git.Repository, error) {
+	if err := b.moveLegacyClone(repoCfg, path); err != nil {
+		return nil, err
+	}
 
-func CloneOrOpenAndUpdate(url, path string) (*git.Repository, error) {
 	if _, err := os.Stat(path); err == nil {
-		return OpenAndFetch(path)
+		return b.OpenAndFetch(repoCfg, path)
 	}
 
-	return Clone(url, path)
+	return b.Clone(repoCfg, path)
 }
 
-func GetAuth() (*ssh.PublicKeys, error) {
-	return ssh.NewPublicKeysFromFile("git", Config.SshKey, "")
+// moveLegacyClone moves a repository cloned into the directory it was given
+// before GitUrlToDirectory separated nested paths, unless it's been cloned
+// into the current one already. Legacy directories can be the current one of
+// another repository, e.g. host_group_subrepo for both host:group/sub/repo and
+// host:group/subrepo, so only clones of the same url are moved.
+func (b *Backend) moveLegacyClone(repoCfg *config.Repository, path string) error {
+	legacyDir := LegacyGitUrlToDirectory(repoCfg.Url)
+
+	if legacyDir == "" {
+		return nil
+	}
+
+	legacyPath := b.Config.GetRepoPath(legacyDir)
+
+	if legacyPath == path {
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	if _, err := os.Stat(legacyPath); err != nil {
+		return nil
+	}
+
+	if !clonedFrom(legacyPath, repoCfg.Url) {
+		return nil
+	}
+
+	return os.Rename(legacyPath, path)
 }
 
-func Clone(url, path string) (*git.Repository, error) {
-	auth, err := GetAuth()
+// clonedFrom reports whether the repository at path was cloned from url.
+func clonedFrom(path, url string) bool {
+	repo, err := git.PlainOpen(path)
+
+	if err != nil {
+		return false
+	}
+
+	remote, err := repo.Remote("origin")
+
+	if err != nil {
+		return false
+	}
+
+	urls := remote.Config().URLs
+
+	return len(urls) > 0 && urls[0] == url
+}
+
+func (b *Backend) Clone(repoCfg *config.Repository, path string) (*git.Repository, error) {
+	auth, err := b.GetAuth(repoCfg)
 
 	if err != nil {
 		return nil, err
 	}
 
-	git.PlainClone(path, false, &git.CloneOptions{
-		URL:  url,
+	_, err = git.PlainClone(path, false, &git.CloneOptions{
+		URL:  repoCfg.Url,
 		Auth: auth,
 	})
 
-	return OpenAndFetch(path)
+	if err != nil {
+		// Don't leave a half cloned repository behind, it would be opened
+		// instead of cloned on the next attempt.
+		os.RemoveAll(path)
+
+		return nil, err
+	}
+
+	return b.OpenAndFetch(repoCfg, path)
 }
 
-func OpenAndFetch(path string) (*git.Repository, error) {
+func (b *Backend) OpenAndFetch(repoCfg *config.Repository, path string) (*git.Repository, error) {
 	repo, err := git.PlainOpen(path)
 
 	if err != nil {
 		return nil, err
 	}
 
-	auth, err := GetAuth()
+	auth, err := b.GetAuth(repoCfg)
 
 	if err != nil {
 		return nil, err
@@ -66,9 +139,26 @@ func OpenAndFetch(path string) (*git.Repository, error) {
 	return repo, nil
 }
 
+// ListRefs lists the references that currently exist on the origin remote.
+func (b *Backend) ListRefs(repoCfg *config.Repository, repo *git.Repository) ([]*plumbing.Reference, error) {
+	remote, err := repo.Remote("origin")
+
+	if err != nil {
+		return nil, err
+	}
+
+	auth, err := b.GetAuth(repoCfg)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return remote.List(&git.ListOptions{Auth: auth})
+}
+
 func CheckoutBranch(repo *git.Repository, worktree *git.Worktree, ref *plumbing.Reference) (string, error) {
 	err := worktree.Checkout(&git.CheckoutOptions{
-		Branch: ref.Target(),
+		Branch: ref.Name(),
 	})
 
 	if err != nil {
@@ -0,0 +1,99 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitignore"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// ArchiveExcludes decides which files are left out of an artifact, the same
+// way composers archiver does. Paths with the export-ignore attribute in
+// .gitattributes are excluded first, then the archive.exclude patterns from
+// composer.json are applied. Patterns follow the .gitignore syntax, a later
+// pattern overrides an earlier one and "!" includes a path again.
+type ArchiveExcludes struct {
+	patterns []gitignore.Pattern
+}
+
+func NewArchiveExcludes(gitattributes []byte, composerExcludes []string) *ArchiveExcludes {
+	excludes := &ArchiveExcludes{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(gitattributes))
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		for _, attribute := range fields[1:] {
+			switch attribute {
+			case "export-ignore":
+				excludes.add(fields[0])
+			case "-export-ignore", "!export-ignore":
+				excludes.add("!" + fields[0])
+			}
+		}
+	}
+
+	for _, pattern := range composerExcludes {
+		excludes.add(pattern)
+	}
+
+	return excludes
+}
+
+// LoadArchiveExcludes reads the .gitattributes and composer.json in the root
+// of a repository, either of which may be missing.
+func LoadArchiveExcludes(repoPath string) (*ArchiveExcludes, error) {
+	gitattributes, err := ioutil.ReadFile(repoPath + "/.gitattributes")
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	var composerExcludes []string
+
+	composerFile, err := composer.LoadFile(repoPath)
+
+	if err == nil {
+		composerExcludes = composerFile.ArchiveExcludes()
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return NewArchiveExcludes(gitattributes, composerExcludes), nil
+}
+
+// Excluded reports whether a slash separated path, relative to the root of
+// the repository, is left out of the artifact.
+func (e *ArchiveExcludes) Excluded(path string, isDir bool) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	excluded := false
+
+	for _, pattern := range e.patterns {
+		switch pattern.Match(parts, isDir) {
+		case gitignore.Exclude:
+			excluded = true
+		case gitignore.Include:
+			excluded = false
+		}
+	}
+
+	return excluded
+}
+
+func (e *ArchiveExcludes) add(pattern string) {
+	pattern = strings.TrimSpace(pattern)
+
+	if pattern == "" || pattern == "!" {
+		return
+	}
+
+	e.patterns = append(e.patterns, gitignore.ParsePattern(pattern, nil))
+}
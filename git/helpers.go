@@ -8,19 +8,65 @@ import (
 )
 
 func GitUrlToDirectory(url string) (string, error) {
-	// "ssh://" is required otherwise go will refuse to parse the string
-	// also it doesn't need to be ssh:// :)
-	urlInfo, err := url2.Parse("ssh://" + url)
+	rawUrl := url
+
+	// scp-like urls (git@github.com:org/repo.git) need turning into a proper
+	// url, otherwise go will refuse to parse them
+	if !strings.Contains(rawUrl, "://") {
+		rawUrl = "ssh://" + strings.Replace(rawUrl, ":", "/", 1)
+	}
+
+	urlInfo, err := url2.Parse(rawUrl)
 
 	if err != nil {
 		return "", errors.New("Unable to parse url " + url)
 	}
 
-	host := strings.Replace(urlInfo.Host, ".", "_", -1)
+	host := urlInfo.Host
+
+	// Local repositories don't have a host
+	if urlInfo.Scheme == "file" && host == "" {
+		host = "local"
+	}
+
+	if host == "" {
+		return "", errors.New("Unable to parse url " + url)
+	}
+
+	host = strings.Replace(host, ".", "_", -1)
 	host = strings.Replace(host, ":", "_", -1)
 
-	path := strings.Replace(urlInfo.Path, "/", "", -1)
+	path := strings.Trim(urlInfo.Path, "/")
 	path = strings.Replace(path, ".git", "", -1)
+	path = strings.Replace(path, "/", "_", -1)
 
 	return strings.ToLower(fmt.Sprintf("%s_%s", host, path)), nil
 }
+
+// LegacyGitUrlToDirectory returns the directory scp-like urls were cloned into
+// before nested paths were separated by underscores, so existing clones can be
+// moved rather than cloned again. Other urls weren't supported and give "".
+func LegacyGitUrlToDirectory(url string) string {
+	if strings.Contains(url, "://") {
+		return ""
+	}
+
+	authority := url
+	path := ""
+
+	if index := strings.Index(url, "/"); index >= 0 {
+		authority, path = url[:index], url[index:]
+	}
+
+	if index := strings.LastIndex(authority, "@"); index >= 0 {
+		authority = authority[index+1:]
+	}
+
+	host := strings.Replace(authority, ".", "_", -1)
+	host = strings.Replace(host, ":", "_", -1)
+
+	path = strings.Replace(path, "/", "", -1)
+	path = strings.Replace(path, ".git", "", -1)
+
+	return strings.ToLower(fmt.Sprintf("%s_%s", host, path))
+}
@@ -0,0 +1,38 @@
+package git_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"testing"
+)
+
+var gitUrlDirectories = [][]string{
+	{"git@github.com:Org/Repo.git", "github_com_org_repo"},
+	{"https://github.com/org/repo.git", "github_com_org_repo"},
+	{"ssh://git@bitbucket.example.com:7999/proj/repo.git", "bitbucket_example_com_7999_proj_repo"},
+	{"https://gitlab.example.com/group/sub/repo.git", "gitlab_example_com_group_sub_repo"},
+	{"file:///srv/git/repo.git", "local_srv_git_repo"},
+}
+
+var legacyGitUrlDirectories = [][]string{
+	{"git@github.com:Org/Repo.git", "github_com_org_repo"},
+	{"git@gitlab.example.com:group/sub/repo.git", "gitlab_example_com_group_subrepo"},
+	{"https://github.com/org/repo.git", ""},
+}
+
+func TestLegacyGitUrlToDirectory(t *testing.T) {
+	for _, test := range legacyGitUrlDirectories {
+		if actual := git.LegacyGitUrlToDirectory(test[0]); actual != test[1] {
+			t.Errorf("[!] LegacyGitUrlToDirectory(%s) = %v; want %v", test[0], actual, test[1])
+		}
+	}
+}
+
+func TestGitUrlToDirectory(t *testing.T) {
+	for _, test := range gitUrlDirectories {
+		actual, err := git.GitUrlToDirectory(test[0])
+
+		if actual != test[1] || err != nil {
+			t.Errorf("[!] GitUrlToDirectory(%s) = %v, %v; want %v", test[0], actual, err, test[1])
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package git
+
+import (
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+// ResolveCommit returns the commit a branch or tag reference points to,
+// peeling annotated tags.
+func ResolveCommit(repo *git.Repository, hash plumbing.Hash) (*object.Commit, error) {
+	tagObject, err := repo.TagObject(hash)
+
+	if err == nil {
+		return tagObject.Commit()
+	}
+
+	return repo.CommitObject(hash)
+}
+
+// ReadFile reads a file from the tree of the given commit or tag without
+// touching the worktree.
+func ReadFile(repo *git.Repository, hash plumbing.Hash, name string) ([]byte, error) {
+	commit, err := ResolveCommit(repo, hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := commit.File(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	contents, err := file.Contents()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(contents), nil
+}
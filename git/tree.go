@@ -0,0 +1,104 @@
+package git
+
+import (
+	"bytes"
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"io"
+	"io/ioutil"
+	"path"
+)
+
+// CreateArtifactFromCommit archives the tree of a commit or tag straight from the
+// repositories objects, without checking it out. composerJson replaces the
+// committed composer.json, and is where archive.exclude is read from.
+// Executable bits and symlinks are kept, and like CreateArtifactFromRepository
+// the artifact only changes when the commit does. The artifacts md5 checksum
+// is returned.
+func CreateArtifactFromCommit(repo *git.Repository, hash plumbing.Hash, target string, composerJson []byte) (string, error) {
+	commit, err := ResolveCommit(repo, hash)
+
+	if err != nil {
+		return "", err
+	}
+
+	tree, err := commit.Tree()
+
+	if err != nil {
+		return "", err
+	}
+
+	excludes, err := loadTreeArchiveExcludes(tree, composerJson)
+
+	if err != nil {
+		return "", err
+	}
+
+	var files []artifactFile
+
+	err = tree.Files().ForEach(func(file *object.File) error {
+		if path.Base(file.Name) == ".git" || excludes.Excluded(file.Name, false) {
+			return nil
+		}
+
+		mode, err := file.Mode.ToOSFileMode()
+
+		if err != nil {
+			return err
+		}
+
+		// A symlinks blob holds its target, which is also how zip stores them
+		open := file.Reader
+		size := file.Size
+
+		if file.Name == "composer.json" {
+			open = func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(composerJson)), nil
+			}
+			size = int64(len(composerJson))
+		}
+
+		files = append(files, artifactFile{
+			Name: file.Name,
+			Mode: mode,
+			Size: size,
+			Open: open,
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return writeArtifact(target, commit.Committer.When, files)
+}
+
+func loadTreeArchiveExcludes(tree *object.Tree, composerJson []byte) (*ArchiveExcludes, error) {
+	var gitattributes []byte
+
+	file, err := tree.File(".gitattributes")
+
+	if err == nil {
+		contents, err := file.Contents()
+
+		if err != nil {
+			return nil, err
+		}
+
+		gitattributes = []byte(contents)
+	} else if err != object.ErrFileNotFound {
+		return nil, err
+	}
+
+	composerData, err := composer.Parse(composerJson)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewArchiveExcludes(gitattributes, composerData.ArchiveExcludes()), nil
+}
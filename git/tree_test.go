@@ -0,0 +1,208 @@
+package git_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	git2 "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateArtifactFromCommit(t *testing.T) {
+	repoPath, err := ioutil.TempDir("", "cloudsmith-sync-tree")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoPath)
+
+	repo, err := git2.PlainInit(repoPath, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		".gitattributes":       "/tests export-ignore\n",
+		"composer.json":        `{"name": "org/package"}`,
+		"bin/console":          "#!/usr/bin/env php",
+		"src/Client.php":       "<?php",
+		"tests/ClientTest.php": "<?php",
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(repoPath, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.Chmod(filepath.Join(repoPath, "bin/console"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("src/Client.php", filepath.Join(repoPath, "Client.php")); err != nil {
+		t.Fatal(err)
+	}
+
+	worktree, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := worktree.Add("."); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := worktree.Commit("Initial commit", &git2.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes to the worktree must not end up in the artifact
+	if err := ioutil.WriteFile(filepath.Join(repoPath, "src/Client.php"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	target := repoPath + ".zip"
+	defer os.Remove(target)
+
+	composerJson := []byte(`{"name": "org/package", "version": "1.0.0"}`)
+
+	checksum, err := git.CreateArtifactFromCommit(repo, hash, target, composerJson)
+
+	if err != nil {
+		t.Fatalf("[!] CreateArtifactFromCommit() returned %v", err)
+	}
+
+	rebuilt := repoPath + "-rebuilt.zip"
+	defer os.Remove(rebuilt)
+
+	if _, err := git.CreateArtifactFromCommit(repo, hash, rebuilt, composerJson); err != nil {
+		t.Fatalf("[!] CreateArtifactFromCommit() returned %v", err)
+	}
+
+	first, _ := ioutil.ReadFile(target)
+	second, _ := ioutil.ReadFile(rebuilt)
+
+	if !bytes.Equal(first, second) {
+		t.Errorf("[!] artifacts built from the same commit differ")
+	}
+
+	if sum := md5.Sum(first); checksum != hex.EncodeToString(sum[:]) {
+		t.Errorf("[!] CreateArtifactFromCommit() returned the checksum %s; want %x", checksum, sum)
+	}
+
+	reader, err := zip.OpenReader(target)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	expected := map[string]struct {
+		mode     os.FileMode
+		contents string
+	}{
+		".gitattributes": {0644, "/tests export-ignore\n"},
+		"Client.php":     {os.ModeSymlink | os.ModePerm, "src/Client.php"},
+		"bin/console":    {0755, "#!/usr/bin/env php"},
+		"composer.json":  {0644, string(composerJson)},
+		"src/Client.php": {0644, "<?php"},
+	}
+
+	if len(reader.File) != len(expected) {
+		t.Errorf("[!] artifact contains %d files; want %d", len(reader.File), len(expected))
+	}
+
+	for _, file := range reader.File {
+		want, ok := expected[file.Name]
+
+		if !ok {
+			t.Errorf("[!] artifact unexpectedly contains %s", file.Name)
+			continue
+		}
+
+		if file.Mode() != want.mode {
+			t.Errorf("[!] %s has mode %v; want %v", file.Name, file.Mode(), want.mode)
+		}
+
+		contents, err := file.Open()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual, err := ioutil.ReadAll(contents)
+		contents.Close()
+
+		if err != nil || string(actual) != want.contents {
+			t.Errorf("[!] %s contains %q; want %q", file.Name, actual, want.contents)
+		}
+	}
+
+	// Tar headers are written from the sizes in the tree, except for the
+	// rewritten composer.json
+	tarTarget := repoPath + ".tar"
+	defer os.Remove(tarTarget)
+
+	if _, err := git.CreateArtifactFromCommit(repo, hash, tarTarget, composerJson); err != nil {
+		t.Fatalf("[!] CreateArtifactFromCommit(tar) returned %v", err)
+	}
+
+	tarFile, err := os.Open(tarTarget)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tarFile.Close()
+
+	archive := tar.NewReader(tarFile)
+	entries := 0
+
+	for {
+		header, err := archive.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("[!] reading the tar artifact returned %v", err)
+		}
+
+		entries++
+
+		actual, err := ioutil.ReadAll(archive)
+
+		if header.Typeflag == tar.TypeSymlink {
+			actual = []byte(header.Linkname)
+		}
+
+		if want := expected[header.Name]; err != nil || string(actual) != want.contents {
+			t.Errorf("[!] %s in the tar artifact contains %q; want %q", header.Name, actual, want.contents)
+		}
+	}
+
+	if entries != len(expected) {
+		t.Errorf("[!] tar artifact contains %d files; want %d", entries, len(expected))
+	}
+}
@@ -1,40 +1,71 @@
 package git
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
 	"io"
+	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
-func CreateArtifactFromRepository(repoPath, target string) error {
-	repoPath = repoPath + "/."
+// artifactFile is a file to be added to an artifact. Open returns its
+// contents, or the target of a symlink. Size is the length of the contents of
+// regular files, which tar headers need up front.
+type artifactFile struct {
+	Name string
+	Mode os.FileMode
+	Size int64
+	Open func() (io.ReadCloser, error)
+}
+
+// CreateArtifactFromRepository archives the working tree of a repository, leaving
+// out the .git directory and anything excluded by ArchiveExcludes. Every entry
+// gets the modified time given, usually the commits time, so the same commit
+// always produces the same artifact. The artifacts md5 checksum is returned.
+func CreateArtifactFromRepository(repoPath, target string, modified time.Time) (string, error) {
+	excludes, err := LoadArchiveExcludes(repoPath)
 
-	zipfile, err := os.Create(target)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer zipfile.Close()
 
-	archive := zip.NewWriter(zipfile)
-	defer archive.Close()
+	repoPath = repoPath + "/."
 
 	_, err = os.Stat(repoPath)
+
 	if err != nil {
-		return nil
+		return "", err
 	}
 
 	basePath := filepath.Dir(repoPath)
 
-	filepath.Walk(repoPath, func(filePath string, fileInfo os.FileInfo, err error) error {
-		if err != nil || fileInfo.IsDir() {
+	var files []artifactFile
+
+	err = filepath.Walk(repoPath, func(filePath string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
 			return err
 		}
 
-		// Ensure the archive doesn't contain the git repository
-		if strings.Contains(filePath, ".git") {
+		// Ensure the archive doesn't contain the git repository, or the .git
+		// files submodules use to point at theirs
+		if fileInfo.Name() == ".git" {
+			if fileInfo.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if fileInfo.IsDir() {
 			return nil
 		}
 
@@ -43,24 +74,213 @@ func CreateArtifactFromRepository(repoPath, target string) error {
 			return err
 		}
 
-		archivePath := path.Join(filepath.SplitList(relativeFilePath)...)
+		archivePath := filepath.ToSlash(relativeFilePath)
+
+		if excludes.Excluded(archivePath, false) {
+			return nil
+		}
+
+		file := artifactFile{
+			Name: archivePath,
+			Mode: fileInfo.Mode(),
+			Size: fileInfo.Size(),
+			Open: func() (io.ReadCloser, error) {
+				return os.Open(filePath)
+			},
+		}
+
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			file.Open = func() (io.ReadCloser, error) {
+				link, err := os.Readlink(filePath)
+
+				if err != nil {
+					return nil, err
+				}
+
+				return ioutil.NopCloser(bytes.NewReader([]byte(filepath.ToSlash(link)))), nil
+			}
+		}
+
+		files = append(files, file)
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return writeArtifact(target, modified, files)
+}
+
+// writeArtifact writes the files to an archive in a reproducible way, sorted by
+// name with a fixed modified time and normalised permissions. The format is
+// picked from the targets extension, .zip, .tar or .tar.gz. The md5 checksum
+// is calculated as the archive is written, so it doesn't have to be read again
+// to upload it.
+func writeArtifact(target string, modified time.Time, files []artifactFile) (string, error) {
+	var write func(io.Writer, time.Time, []artifactFile) error
+
+	switch {
+	case strings.HasSuffix(target, ".zip"):
+		write = writeZip
+	case strings.HasSuffix(target, ".tar"):
+		write = writeTar
+	case strings.HasSuffix(target, ".tar.gz"):
+		write = writeTarGz
+	default:
+		return "", errors.New("unsupported archive format " + filepath.Base(target))
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+
+	archiveFile, err := os.Create(target)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := md5.New()
+
+	err = write(io.MultiWriter(archiveFile, checksum), modified, files)
+
+	if err != nil {
+		archiveFile.Close()
+		return "", err
+	}
+
+	if err := archiveFile.Close(); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(checksum.Sum(nil)), nil
+}
+
+func writeZip(w io.Writer, modified time.Time, files []artifactFile) error {
+	archive := zip.NewWriter(w)
+
+	for _, file := range files {
+		err := writeZipFile(archive, modified, file)
+
+		if err != nil {
+			archive.Close()
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func writeZipFile(archive *zip.Writer, modified time.Time, file artifactFile) error {
+	header := &zip.FileHeader{
+		Name:     file.Name,
+		Method:   zip.Deflate,
+		Modified: modified.UTC(),
+	}
+	header.SetMode(normaliseMode(file.Mode))
+
+	writer, err := archive.CreateHeader(header)
+
+	if err != nil {
+		return err
+	}
+
+	reader, err := file.Open()
+
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(writer, reader)
+
+	return err
+}
+
+func writeTarGz(w io.Writer, modified time.Time, files []artifactFile) error {
+	// The gzip header is left without a name or time to keep it reproducible
+	compressor := gzip.NewWriter(w)
+
+	err := writeTar(compressor, modified, files)
+
+	if err != nil {
+		compressor.Close()
+		return err
+	}
+
+	return compressor.Close()
+}
+
+func writeTar(w io.Writer, modified time.Time, files []artifactFile) error {
+	archive := tar.NewWriter(w)
+
+	for _, file := range files {
+		err := writeTarFile(archive, modified, file)
 
-		file, err := os.Open(filePath)
 		if err != nil {
+			archive.Close()
 			return err
 		}
-		defer func() {
-			_ = file.Close()
-		}()
+	}
+
+	return archive.Close()
+}
+
+func writeTarFile(archive *tar.Writer, modified time.Time, file artifactFile) error {
+	reader, err := file.Open()
+
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	mode := normaliseMode(file.Mode)
+
+	header := &tar.Header{
+		Name:    file.Name,
+		Mode:    int64(mode.Perm()),
+		ModTime: modified.UTC().Truncate(time.Second),
+	}
+
+	if mode&os.ModeSymlink != 0 {
+		link, err := ioutil.ReadAll(reader)
 
-		zipFileWriter, err := archive.Create(archivePath)
 		if err != nil {
 			return err
 		}
 
-		_, err = io.Copy(zipFileWriter, file)
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = string(link)
+
+		return archive.WriteHeader(header)
+	}
+
+	header.Typeflag = tar.TypeReg
+	header.Size = file.Size
+
+	err = archive.WriteHeader(header)
+
+	if err != nil {
 		return err
-	})
+	}
+
+	// The tar writer fails if the contents don't match the size in the header
+	_, err = io.Copy(archive, reader)
 
 	return err
 }
+
+// normaliseMode reduces a files mode to what git tracks, a symlink, an
+// executable or a regular file, so artifacts don't depend on the umask.
+func normaliseMode(mode os.FileMode) os.FileMode {
+	if mode&os.ModeSymlink != 0 {
+		return os.ModeSymlink | os.ModePerm
+	}
+
+	if mode&0111 != 0 {
+		return 0755
+	}
+
+	return 0644
+}
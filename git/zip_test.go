@@ -0,0 +1,296 @@
+package git_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+var archiveExcludeTests = []struct {
+	path     string
+	expected bool
+}{
+	{"src/Client.php", false},
+	{"tests/ClientTest.php", true},
+	{"src/tests/Fixture.php", false},
+	{"docs/index.md", true},
+	{"docs/README.md", false},
+	{".github/workflows/ci.yml", true},
+	{".gitignore", false},
+	{"phpunit.xml.dist", true},
+	{"build/cache.json", true},
+	{"build/keep.php", false},
+}
+
+func TestArchiveExcludes(t *testing.T) {
+	gitattributes := []byte("# tooling\n/tests export-ignore\n/docs export-ignore\n/docs/README.md -export-ignore\n/.github export-ignore\n*.dist export-ignore\n* text=auto\n")
+	excludes := git.NewArchiveExcludes(gitattributes, []string{"/build", "!/build/keep.php"})
+
+	for _, test := range archiveExcludeTests {
+		if actual := excludes.Excluded(test.path, false); actual != test.expected {
+			t.Errorf("[!] Excluded(%s) = %v; want %v", test.path, actual, test.expected)
+		}
+	}
+}
+
+func TestCreateArtifactFromRepository(t *testing.T) {
+	repoPath, err := ioutil.TempDir("", "cloudsmith-sync-zip")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoPath)
+
+	files := map[string]string{
+		".git/HEAD":            "ref: refs/heads/master",
+		".gitattributes":       "/tests export-ignore\n",
+		".github/FUNDING.yml":  "github: org",
+		"composer.json":        `{"name": "org/package", "archive": {"exclude": ["/Makefile"]}}`,
+		"Makefile":             "test:",
+		"src/Client.php":       "<?php",
+		"tests/ClientTest.php": "<?php",
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(repoPath, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := repoPath + "/../" + filepath.Base(repoPath) + ".zip"
+	defer os.Remove(target)
+
+	if _, err := git.CreateArtifactFromRepository(repoPath, target, time.Now()); err != nil {
+		t.Fatalf("[!] CreateArtifactFromRepository() returned %v", err)
+	}
+
+	reader, err := zip.OpenReader(target)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	var names []string
+
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+
+	sort.Strings(names)
+
+	expected := []string{".gitattributes", ".github/FUNDING.yml", "composer.json", "src/Client.php"}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("[!] artifact contains %v; want %v", names, expected)
+	}
+}
+
+func TestCreateArtifactFromRepositoryMissingRepository(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-zip")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	target := filepath.Join(dir, "package.zip")
+
+	if _, err := git.CreateArtifactFromRepository(filepath.Join(dir, "missing"), target, time.Now()); err == nil {
+		t.Errorf("[!] CreateArtifactFromRepository() of a missing repository didn't return an error")
+	}
+}
+
+func TestCreateArtifactFromRepositoryIsReproducible(t *testing.T) {
+	repoPath, err := ioutil.TempDir("", "cloudsmith-sync-zip")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoPath)
+
+	for _, name := range []string{"b.php", "a/z.php", "a.php", "c/d/e.php"} {
+		path := filepath.Join(repoPath, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	commitTime := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	var artifacts [][]byte
+
+	for i := 0; i < 2; i++ {
+		// Neither mtimes nor permissions beyond the executable bit may leak
+		// into the artifact
+		later := time.Now().Add(time.Duration(i) * time.Hour)
+
+		if err := os.Chtimes(filepath.Join(repoPath, "b.php"), later, later); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Chmod(filepath.Join(repoPath, "a.php"), os.FileMode(0600+i*0040)); err != nil {
+			t.Fatal(err)
+		}
+
+		target := repoPath + ".zip"
+
+		if _, err := git.CreateArtifactFromRepository(repoPath, target, commitTime); err != nil {
+			t.Fatalf("[!] CreateArtifactFromRepository() returned %v", err)
+		}
+
+		artifact, err := ioutil.ReadFile(target)
+		os.Remove(target)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		artifacts = append(artifacts, artifact)
+	}
+
+	if !bytes.Equal(artifacts[0], artifacts[1]) {
+		t.Fatalf("[!] artifacts of the same files differ")
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(artifacts[0]), int64(len(artifacts[0])))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+
+		if !file.Modified.Equal(commitTime) || file.Mode() != 0644 {
+			t.Errorf("[!] %s has modified time %v and mode %v; want %v and %v", file.Name, file.Modified, file.Mode(), commitTime, os.FileMode(0644))
+		}
+	}
+
+	expected := []string{"a.php", "a/z.php", "b.php", "c/d/e.php"}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("[!] artifact entries are %v; want %v", names, expected)
+	}
+}
+
+func readTarEntries(t *testing.T, path string, compressed bool) map[string]*tar.Header {
+	file, err := os.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var reader io.Reader = file
+
+	if compressed {
+		gzipReader, err := gzip.NewReader(file)
+
+		if err != nil {
+			t.Fatalf("[!] %s isn't gzipped: %v", path, err)
+		}
+
+		reader = gzipReader
+	}
+
+	entries := make(map[string]*tar.Header)
+	archive := tar.NewReader(reader)
+
+	for {
+		header, err := archive.Next()
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		entries[header.Name] = header
+	}
+
+	return entries
+}
+
+func TestCreateArtifactFromRepositoryTar(t *testing.T) {
+	repoPath, err := ioutil.TempDir("", "cloudsmith-sync-tar")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repoPath)
+
+	if err := os.MkdirAll(filepath.Join(repoPath, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(repoPath, "composer.json"), []byte(`{"name": "org/package"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(repoPath, "bin/console"), []byte("#!/usr/bin/env php"), 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("bin/console", filepath.Join(repoPath, "console")); err != nil {
+		t.Fatal(err)
+	}
+
+	commitTime := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, extension := range []string{".tar", ".tar.gz"} {
+		target := repoPath + extension
+		defer os.Remove(target)
+
+		if _, err := git.CreateArtifactFromRepository(repoPath, target, commitTime); err != nil {
+			t.Fatalf("[!] CreateArtifactFromRepository(%s) returned %v", extension, err)
+		}
+
+		entries := readTarEntries(t, target, extension == ".tar.gz")
+
+		if len(entries) != 3 {
+			t.Errorf("[!] %s contains %d entries; want 3", extension, len(entries))
+		}
+
+		if header := entries["composer.json"]; header == nil || header.Mode != 0644 || header.Size != 23 || !header.ModTime.Equal(commitTime) {
+			t.Errorf("[!] %s has unexpected composer.json header %+v", extension, header)
+		}
+
+		if header := entries["bin/console"]; header == nil || header.Mode != 0755 {
+			t.Errorf("[!] %s has unexpected bin/console header %+v", extension, header)
+		}
+
+		if header := entries["console"]; header == nil || header.Typeflag != tar.TypeSymlink || header.Linkname != "bin/console" {
+			t.Errorf("[!] %s has unexpected console header %+v", extension, header)
+		}
+	}
+
+	if _, err := git.CreateArtifactFromRepository(repoPath, repoPath+".rar", commitTime); err == nil {
+		t.Errorf("[!] expected an error for an unsupported archive format")
+	}
+}
@@ -0,0 +1,237 @@
+package githubapp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultBaseUrl = "https://api.github.com"
+
+// Tokens are refreshed this long before they expire, so a token handed out is
+// still valid for the duration of a clone or fetch.
+const refreshMargin = 5 * time.Minute
+
+// App authenticates as a GitHub App installation. It mints installation
+// tokens from the apps private key, and caches them until they're about to
+// expire.
+type App struct {
+	ID             int64
+	InstallationID int64
+	BaseUrl        string
+	HTTPClient     *http.Client
+
+	privateKey *rsa.PrivateKey
+
+	mutex     sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// Repository is a repository the installation has access to.
+type Repository struct {
+	Name          string   `json:"name"`
+	FullName      string   `json:"full_name"`
+	CloneURL      string   `json:"clone_url"`
+	SSHURL        string   `json:"ssh_url"`
+	DefaultBranch string   `json:"default_branch"`
+	Topics        []string `json:"topics"`
+	Private       bool     `json:"private"`
+	Archived      bool     `json:"archived"`
+}
+
+type Error struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("github api responded with %d: %s", e.StatusCode, e.Message)
+}
+
+func NewApp(id, installationID int64, privateKeyPEM []byte, baseUrl string) (*App, error) {
+	privateKey, err := parsePrivateKey(privateKeyPEM)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if baseUrl == "" {
+		baseUrl = DefaultBaseUrl
+	}
+
+	return &App{
+		ID:             id,
+		InstallationID: installationID,
+		BaseUrl:        strings.TrimRight(baseUrl, "/"),
+		HTTPClient:     http.DefaultClient,
+		privateKey:     privateKey,
+	}, nil
+}
+
+// InstallationToken returns a token for the installation, minting a new one
+// when there's no cached token or it's about to expire.
+func (a *App) InstallationToken() (string, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.token != "" && time.Now().Add(refreshMargin).Before(a.expiresAt) {
+		return a.token, nil
+	}
+
+	jwt, err := a.jwt(time.Now())
+
+	if err != nil {
+		return "", err
+	}
+
+	var response struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	url := fmt.Sprintf("%s/app/installations/%d/access_tokens", a.BaseUrl, a.InstallationID)
+
+	if err := a.do("POST", url, "Bearer "+jwt, &response); err != nil {
+		return "", err
+	}
+
+	a.token = response.Token
+	a.expiresAt = response.ExpiresAt
+
+	return a.token, nil
+}
+
+// ListRepositories lists every repository the installation can access.
+func (a *App) ListRepositories() ([]Repository, error) {
+	var repositories []Repository
+
+	for page := 1; ; page++ {
+		token, err := a.InstallationToken()
+
+		if err != nil {
+			return nil, err
+		}
+
+		var response struct {
+			TotalCount   int          `json:"total_count"`
+			Repositories []Repository `json:"repositories"`
+		}
+
+		url := fmt.Sprintf("%s/installation/repositories?per_page=100&page=%d", a.BaseUrl, page)
+
+		if err := a.do("GET", url, "token "+token, &response); err != nil {
+			return nil, err
+		}
+
+		repositories = append(repositories, response.Repositories...)
+
+		if len(response.Repositories) == 0 || len(repositories) >= response.TotalCount {
+			break
+		}
+	}
+
+	return repositories, nil
+}
+
+// jwt creates the short lived token that authenticates as the app itself.
+func (a *App) jwt(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+
+	if err != nil {
+		return "", err
+	}
+
+	// Backdate the token a little to allow for clock drift
+	claims, err := json.Marshal(map[string]int64{
+		"iat": now.Add(-60 * time.Second).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": a.ID,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, hash[:])
+
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (a *App) do(method, url, authorization string, target interface{}) error {
+	req, err := http.NewRequest(method, url, nil)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := a.HTTPClient.Do(req)
+
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		apiError := &Error{StatusCode: resp.StatusCode}
+		json.Unmarshal(body, apiError)
+
+		return apiError
+	}
+
+	return json.Unmarshal(body, target)
+}
+
+func parsePrivateKey(privateKeyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(privateKeyPEM)
+
+	if block == nil {
+		return nil, errors.New("github app private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+
+	if !ok {
+		return nil, errors.New("github app private key is not an RSA key")
+	}
+
+	return rsaKey, nil
+}
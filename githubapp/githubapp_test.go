@@ -0,0 +1,137 @@
+package githubapp_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/githubapp"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestApp(t *testing.T, handler http.Handler) (*githubapp.App, *rsa.PrivateKey, func()) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	server := httptest.NewServer(handler)
+
+	app, err := githubapp.NewApp(42, 7, keyPEM, server.URL)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app, key, server.Close
+}
+
+func verifyJWT(t *testing.T, key *rsa.PrivateKey, authorization string) {
+	parts := strings.Split(strings.TrimPrefix(authorization, "Bearer "), ".")
+
+	if len(parts) != 3 {
+		t.Fatalf("[!] authorization %q is not a JWT", authorization)
+	}
+
+	signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hash[:], signature); err != nil {
+		t.Errorf("[!] JWT signature is invalid: %v", err)
+	}
+
+	rawClaims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+
+	var claims map[string]int64
+	json.Unmarshal(rawClaims, &claims)
+
+	if claims["iss"] != 42 {
+		t.Errorf("[!] JWT iss = %d; want 42", claims["iss"])
+	}
+}
+
+func TestInstallationTokenIsCached(t *testing.T) {
+	var key *rsa.PrivateKey
+	minted := 0
+	expiresAt := time.Now().Add(time.Minute)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		verifyJWT(t, key, r.Header.Get("Authorization"))
+		minted++
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      fmt.Sprintf("token-%d", minted),
+			"expires_at": expiresAt,
+		})
+	})
+
+	app, appKey, teardown := newTestApp(t, mux)
+	defer teardown()
+	key = appKey
+
+	// Tokens that are about to expire aren't handed out again
+	app.InstallationToken()
+	app.InstallationToken()
+
+	if minted != 2 {
+		t.Errorf("[!] minted %d tokens; want 2 as tokens expiring soon are refreshed", minted)
+	}
+
+	expiresAt = time.Now().Add(time.Hour)
+
+	for i := 0; i < 3; i++ {
+		token, err := app.InstallationToken()
+
+		if err != nil || token != "token-3" {
+			t.Fatalf("[!] InstallationToken() = %v, %v; want the cached token-3", token, err)
+		}
+	}
+}
+
+func TestListRepositories(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/app/installations/7/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token":      "installation-token",
+			"expires_at": time.Now().Add(time.Hour),
+		})
+	})
+	mux.HandleFunc("/installation/repositories", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token installation-token" {
+			w.WriteHeader(401)
+			return
+		}
+
+		repositories := []githubapp.Repository{{FullName: "org/a"}, {FullName: "org/b"}}
+
+		if r.URL.Query().Get("page") == "2" {
+			repositories = []githubapp.Repository{{FullName: "org/c"}}
+		}
+
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"total_count":  3,
+			"repositories": repositories,
+		})
+	})
+
+	app, _, teardown := newTestApp(t, mux)
+	defer teardown()
+
+	repositories, err := app.ListRepositories()
+
+	if err != nil || len(repositories) != 3 || repositories[2].FullName != "org/c" {
+		t.Errorf("[!] ListRepositories() = %v, %v; want org/a, org/b and org/c", repositories, err)
+	}
+}
@@ -0,0 +1,206 @@
+package journal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// compactAfter is the fewest logged changes worth writing a new snapshot for.
+const compactAfter = 256
+
+// Journal persists a JSON snapshot along with a log of the changes made since
+// it was written. Changes are appended to the log, so recording one doesn't
+// rewrite everything, and Compact folds them back into a new snapshot. The
+// snapshot is replaced atomically and a change that was being appended during
+// a crash is dropped when the journal is next opened, so at most that change
+// is lost.
+type Journal struct {
+	path    string
+	logPath string
+	records int
+}
+
+// Open reads the snapshot at path into snapshot, leaving it untouched when
+// there's no snapshot yet. Replay applies the changes logged since.
+func Open(path string, snapshot interface{}) (*Journal, error) {
+	j := &Journal{path: path, logPath: LogPath(path)}
+
+	raw, err := ioutil.ReadFile(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		if err := json.Unmarshal(raw, snapshot); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := j.dropTornChange(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// dropTornChange truncates the log back to the end of its last complete line,
+// removing a change that was being appended when the process stopped, so the
+// next change isn't appended onto it.
+func (j *Journal) dropTornChange() error {
+	raw, err := ioutil.ReadFile(j.logPath)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if len(raw) == 0 || raw[len(raw)-1] == '\n' {
+		return nil
+	}
+
+	return os.Truncate(j.logPath, int64(bytes.LastIndexByte(raw, '\n')+1))
+}
+
+// LogPath returns where the changes to the snapshot at path are logged.
+func LogPath(path string) string {
+	return path + ".log"
+}
+
+// Replay calls apply with every change logged since the snapshot, in the
+// order they were appended.
+func (j *Journal) Replay(apply func(raw json.RawMessage) error) error {
+	raw, err := ioutil.ReadFile(j.logPath)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 64*1024), len(raw)+1)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		// Open drops a change that was torn by a crash, any other invalid
+		// line is skipped so it doesn't hide the changes after it
+		if !json.Valid(line) {
+			continue
+		}
+
+		if err := apply(json.RawMessage(line)); err != nil {
+			return err
+		}
+
+		j.records++
+	}
+
+	return scanner.Err()
+}
+
+// Append logs changes, with a single write.
+func (j *Journal) Append(records ...interface{}) error {
+	var raw []byte
+
+	for _, record := range records {
+		line, err := json.Marshal(record)
+
+		if err != nil {
+			return err
+		}
+
+		raw = append(append(raw, line...), '\n')
+	}
+
+	file, err := os.OpenFile(j.logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(raw); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	j.records += len(records)
+
+	return file.Close()
+}
+
+// Len returns how many changes have been logged since the snapshot.
+func (j *Journal) Len() int {
+	return j.records
+}
+
+// NeedsCompacting reports whether the log has grown long enough, compared to
+// the size of the snapshot, that it should be folded into a new one.
+func (j *Journal) NeedsCompacting(size int) bool {
+	return j.records >= compactAfter && j.records >= size
+}
+
+// Compact replaces the snapshot with the given one and clears the log.
+func (j *Journal) Compact(snapshot interface{}) error {
+	raw, err := json.MarshalIndent(snapshot, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(j.path), filepath.Base(j.path)+".tmp")
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := file.Name()
+
+	_, err = file.Write(raw)
+
+	if err == nil {
+		err = file.Sync()
+	}
+
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(tmpPath, 0644)
+	}
+
+	if err == nil {
+		err = os.Rename(tmpPath, j.path)
+	}
+
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	// Should this fail, the changes are replayed over a snapshot that already
+	// has them, which ends up the same
+	if err := os.Remove(j.logPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	j.records = 0
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package journal_test
+
+import (
+	"encoding/json"
+	"github.com/Lavoaster/cloudsmith-sync/journal"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type snapshot struct {
+	Values []string `json:"values"`
+}
+
+func replay(t *testing.T, path string) (snapshot, []string, *journal.Journal) {
+	var persisted snapshot
+
+	j, err := journal.Open(path, &persisted)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logged []string
+
+	err = j.Replay(func(raw json.RawMessage) error {
+		var value string
+
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return err
+		}
+
+		logged = append(logged, value)
+
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return persisted, logged, j
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-journal")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	_, _, j := replay(t, path)
+
+	if err := j.Append("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Append("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A change that was being written when the process stopped
+	file, err := os.OpenFile(path+".log", os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte(`"d`))
+	file.Close()
+
+	persisted, logged, j := replay(t, path)
+
+	if len(persisted.Values) != 0 || !reflect.DeepEqual(logged, []string{"a", "b", "c"}) {
+		t.Errorf("[!] replayed %v, %v; want no snapshot and a, b, c", persisted.Values, logged)
+	}
+
+	if j.Len() != 3 {
+		t.Errorf("[!] Len() = %d; want 3", j.Len())
+	}
+
+	if err := j.Compact(snapshot{Values: []string{"a", "b", "c"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Append("e"); err != nil {
+		t.Fatal(err)
+	}
+
+	persisted, logged, _ = replay(t, path)
+
+	if !reflect.DeepEqual(persisted.Values, []string{"a", "b", "c"}) || !reflect.DeepEqual(logged, []string{"e"}) {
+		t.Errorf("[!] replayed %v, %v; want a snapshot of a, b, c and e", persisted.Values, logged)
+	}
+}
+
+func TestJournalAppendsAfterTornChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-journal")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+
+	_, _, j := replay(t, path)
+
+	if err := j.Append("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(path+".log", os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file.Write([]byte(`"b`))
+	file.Close()
+
+	_, _, j = replay(t, path)
+
+	if err := j.Append("c"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := j.Append("d"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, logged, j := replay(t, path)
+
+	if !reflect.DeepEqual(logged, []string{"a", "c", "d"}) {
+		t.Errorf("[!] replayed %v; want a, c, d", logged)
+	}
+
+	if j.Len() != 3 {
+		t.Errorf("[!] Len() = %d; want 3", j.Len())
+	}
+}
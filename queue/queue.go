@@ -0,0 +1,213 @@
+package queue
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Job asks for a single ref of a repository to be synced.
+type Job struct {
+	Repository string    `json:"repository"`
+	Ref        string    `json:"ref"`
+	Commit     string    `json:"commit,omitempty"`
+	QueuedAt   time.Time `json:"queuedAt"`
+}
+
+func (job Job) key() string {
+	return job.Repository + "#" + job.Ref
+}
+
+type persistedQueue struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// Queue runs jobs in the background with a pool of workers. Jobs for the same
+// repository never run at the same time, as they share a worktree, and a job
+// that's queued while an identical one is still waiting is dropped.
+//
+// Waiting and running jobs are persisted to disk, so they're picked up again
+// after a restart.
+type Queue struct {
+	path    string
+	handler func(Job)
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	pending []Job
+	running map[string]Job
+	stopped bool
+	workers sync.WaitGroup
+}
+
+// New creates a queue persisted at path, restoring any jobs that were left
+// over from a previous run.
+func New(path string, handler func(Job)) (*Queue, error) {
+	q := &Queue{
+		path:    path,
+		handler: handler,
+		running: make(map[string]Job),
+	}
+	q.cond = sync.NewCond(&q.mutex)
+
+	raw, err := ioutil.ReadFile(path)
+
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err == nil {
+		var persisted persistedQueue
+
+		if err := json.Unmarshal(raw, &persisted); err != nil {
+			return nil, err
+		}
+
+		for _, job := range persisted.Jobs {
+			q.add(job)
+		}
+	}
+
+	return q, nil
+}
+
+// Enqueue adds a job to the queue unless an identical job is already waiting.
+// It reports whether the job was added.
+func (q *Queue) Enqueue(job Job) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if job.QueuedAt.IsZero() {
+		job.QueuedAt = time.Now()
+	}
+
+	if !q.add(job) {
+		return false, nil
+	}
+
+	if err := q.save(); err != nil {
+		return true, err
+	}
+
+	q.cond.Broadcast()
+
+	return true, nil
+}
+
+// Len returns the number of jobs that are waiting or running.
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.pending) + len(q.running)
+}
+
+// Start launches the given number of workers.
+func (q *Queue) Start(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	for i := 0; i < workers; i++ {
+		q.workers.Add(1)
+
+		go q.work()
+	}
+}
+
+// Stop waits for running jobs to finish and stops the workers. Jobs that are
+// still waiting stay persisted for the next start.
+func (q *Queue) Stop() {
+	q.mutex.Lock()
+	q.stopped = true
+	q.cond.Broadcast()
+	q.mutex.Unlock()
+
+	q.workers.Wait()
+}
+
+func (q *Queue) add(job Job) bool {
+	for _, pending := range q.pending {
+		if pending.key() == job.key() {
+			return false
+		}
+	}
+
+	q.pending = append(q.pending, job)
+
+	return true
+}
+
+func (q *Queue) work() {
+	defer q.workers.Done()
+
+	for {
+		job, ok := q.next()
+
+		if !ok {
+			return
+		}
+
+		q.handler(job)
+
+		q.mutex.Lock()
+		delete(q.running, job.Repository)
+		q.save()
+		q.cond.Broadcast()
+		q.mutex.Unlock()
+	}
+}
+
+// next blocks until there's a job for a repository that isn't busy, or the
+// queue is stopped.
+func (q *Queue) next() (Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for {
+		if q.stopped {
+			return Job{}, false
+		}
+
+		for i, job := range q.pending {
+			if _, busy := q.running[job.Repository]; busy {
+				continue
+			}
+
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.running[job.Repository] = job
+
+			return job, true
+		}
+
+		q.cond.Wait()
+	}
+}
+
+// save writes the running and waiting jobs to disk, running ones first so
+// they're retried first after a crash. The caller must hold the mutex.
+func (q *Queue) save() error {
+	persisted := persistedQueue{Jobs: []Job{}}
+
+	for _, job := range q.running {
+		persisted.Jobs = append(persisted.Jobs, job)
+	}
+
+	persisted.Jobs = append(persisted.Jobs, q.pending...)
+
+	raw, err := json.MarshalIndent(persisted, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	tmpPath := q.path + ".tmp"
+
+	if err := ioutil.WriteFile(tmpPath, raw, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, q.path)
+}
@@ -0,0 +1,141 @@
+package queue_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/queue"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func tempQueuePath(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "queue")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return filepath.Join(dir, "queue.json")
+}
+
+func TestEnqueueCollapsesDuplicates(t *testing.T) {
+	path := tempQueuePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	q, err := queue.New(path, func(job queue.Job) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := []queue.Job{
+		{Repository: "git@github.com:org/a.git", Ref: "refs/heads/master"},
+		{Repository: "git@github.com:org/a.git", Ref: "refs/heads/master"},
+		{Repository: "git@github.com:org/a.git", Ref: "refs/heads/develop"},
+	}
+
+	for _, job := range jobs {
+		if _, err := q.Enqueue(job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if q.Len() != 2 {
+		t.Errorf("[!] Len() = %d; want 2", q.Len())
+	}
+}
+
+func TestQueueIsPersisted(t *testing.T) {
+	path := tempQueuePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	q, err := queue.New(path, func(job queue.Job) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q.Enqueue(queue.Job{Repository: "git@github.com:org/a.git", Ref: "refs/heads/master"})
+	q.Enqueue(queue.Job{Repository: "git@github.com:org/b.git", Ref: "refs/tags/1.0.0"})
+
+	var handled []queue.Job
+	var mutex sync.Mutex
+	done := make(chan struct{}, 2)
+
+	restored, err := queue.New(path, func(job queue.Job) {
+		mutex.Lock()
+		handled = append(handled, job)
+		mutex.Unlock()
+
+		done <- struct{}{}
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored.Start(1)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("[!] restored jobs were not handled")
+		}
+	}
+
+	restored.Stop()
+
+	if len(handled) != 2 || handled[0].Ref != "refs/heads/master" || handled[1].Ref != "refs/tags/1.0.0" {
+		t.Errorf("[!] handled = %v; want both persisted jobs in order", handled)
+	}
+}
+
+func TestJobsAreSerializedPerRepository(t *testing.T) {
+	path := tempQueuePath(t)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	active := make(map[string]int)
+	overlapped := false
+
+	q, err := queue.New(path, func(job queue.Job) {
+		defer wg.Done()
+
+		mutex.Lock()
+		active[job.Repository]++
+		if active[job.Repository] > 1 {
+			overlapped = true
+		}
+		mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		active[job.Repository]--
+		mutex.Unlock()
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refs := []string{"refs/heads/a", "refs/heads/b", "refs/heads/c", "refs/heads/d"}
+	wg.Add(len(refs) * 2)
+
+	for _, ref := range refs {
+		q.Enqueue(queue.Job{Repository: "git@github.com:org/a.git", Ref: ref})
+		q.Enqueue(queue.Job{Repository: "git@github.com:org/b.git", Ref: ref})
+	}
+
+	q.Start(4)
+	wg.Wait()
+	q.Stop()
+
+	if overlapped {
+		t.Error("[!] jobs for the same repository ran at the same time")
+	}
+}
@@ -0,0 +1,114 @@
+package report
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnit writes the results as a JUnit XML report, with a test suite per
+// repository and a test case per ref, so CI systems can display them.
+func WriteJUnit(w io.Writer, results []Result) error {
+	var report junitTestSuites
+
+	suiteIndex := make(map[string]int)
+
+	for _, r := range results {
+		index, ok := suiteIndex[r.Repository]
+
+		if !ok {
+			index = len(report.Suites)
+			suiteIndex[r.Repository] = index
+			report.Suites = append(report.Suites, junitTestSuite{Name: r.Repository})
+		}
+
+		suite := &report.Suites[index]
+
+		name := r.Ref
+
+		if name == "" {
+			name = "repository"
+		}
+
+		if r.Package != "" {
+			name = fmt.Sprintf("%s (%s@%s)", name, r.Package, r.Version)
+		}
+
+		testCase := junitTestCase{
+			Name:      name,
+			ClassName: r.Repository,
+			Time:      fmt.Sprintf("%.3f", r.Duration.Seconds()),
+		}
+
+		switch r.Status {
+		case Failed:
+			testCase.Failure = &junitMessage{Message: r.Detail()}
+			suite.Failures++
+			report.Failures++
+		case Skipped, AlreadyExists:
+			testCase.Skipped = &junitMessage{Message: string(r.Status) + ": " + r.Detail()}
+			suite.Skipped++
+			report.Skipped++
+		}
+
+		suite.Tests++
+		report.Tests++
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	for i := range report.Suites {
+		total := 0.0
+
+		for _, r := range results {
+			if r.Repository == report.Suites[i].Name {
+				total += r.Duration.Seconds()
+			}
+		}
+
+		report.Suites[i].Time = fmt.Sprintf("%.3f", total)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "    ")
+
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
@@ -0,0 +1,135 @@
+package report
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+)
+
+type Status string
+
+// Dry runs report the changes they would have made with the Would statuses.
+const (
+	Published     Status = "published"
+	Skipped       Status = "skipped"
+	AlreadyExists Status = "already-exists"
+	Deleted       Status = "deleted"
+	Resynced      Status = "resynced"
+	WouldPublish  Status = "would-publish"
+	WouldResync   Status = "would-resync"
+	WouldDelete   Status = "would-delete"
+	Failed        Status = "failed"
+)
+
+// Result is the outcome of syncing a single ref. Failures that happen before
+// any ref could be looked at (e.g. a failed clone) have an empty Ref.
+type Result struct {
+	Repository string
+	Ref        string
+	Commit     string
+	Package    string
+	Version    string
+	Status     Status
+	Reason     string
+	Error      error
+	Duration   time.Duration
+	// PackageStatus is how far along Cloudsmith was with processing an
+	// uploaded package, e.g. Awaiting Sync, Completed or Failed.
+	PackageStatus string
+}
+
+// Detail returns the skip reason or error message of the result.
+func (r Result) Detail() string {
+	if r.Error != nil {
+		return r.Error.Error()
+	}
+
+	return r.Reason
+}
+
+func (r Result) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Repository string  `json:"repository"`
+		Ref        string  `json:"ref,omitempty"`
+		Commit     string  `json:"commit,omitempty"`
+		Package    string  `json:"package,omitempty"`
+		Version    string  `json:"version,omitempty"`
+		Status     Status  `json:"status"`
+		Reason     string  `json:"reason,omitempty"`
+		Error      string  `json:"error,omitempty"`
+		Duration   float64 `json:"duration"`
+
+		PackageStatus string `json:"packageStatus,omitempty"`
+	}{
+		Repository: r.Repository,
+		Ref:        r.Ref,
+		Commit:     r.Commit,
+		Package:    r.Package,
+		Version:    r.Version,
+		Status:     r.Status,
+		Reason:     r.Reason,
+		Error:      errorString(r.Error),
+		Duration:   r.Duration.Seconds(),
+
+		PackageStatus: r.PackageStatus,
+	})
+}
+
+// HasFailures reports whether any of the results failed.
+func HasFailures(results []Result) bool {
+	for _, result := range results {
+		if result.Status == Failed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Count returns how many results have the given status.
+func Count(results []Result, status Status) int {
+	count := 0
+
+	for _, result := range results {
+		if result.Status == status {
+			count++
+		}
+	}
+
+	return count
+}
+
+// WriteTable renders the results as a human readable table.
+func WriteTable(w io.Writer, results []Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, "REPOSITORY\tREF\tPACKAGE\tVERSION\tSTATUS\tDETAIL")
+
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", r.Repository, r.Ref, r.Package, r.Version, r.Status, r.Detail())
+	}
+
+	return tw.Flush()
+}
+
+// WriteJSON writes the results as an indented JSON array.
+func WriteJSON(w io.Writer, results []Result) error {
+	if results == nil {
+		results = []Result{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+
+	return enc.Encode(results)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
@@ -0,0 +1,62 @@
+package report_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"strings"
+	"testing"
+)
+
+var results = []report.Result{
+	{Repository: "git@github.com:org/a.git", Ref: "master", Package: "org/a", Version: "dev-master", Status: report.Published},
+	{Repository: "git@github.com:org/a.git", Ref: "1.0.0", Package: "org/a", Version: "1.0.0", Status: report.AlreadyExists, Reason: "package version already exists"},
+	{Repository: "git@github.com:org/b.git", Ref: "develop", Status: report.Skipped, Reason: "branch filtered out by configuration"},
+	{Repository: "git@github.com:org/b.git", Ref: "2.0.0", Package: "org/b", Version: "2.0.0", Status: report.Failed, Error: errors.New("upload failed")},
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := report.WriteJSON(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded []map[string]interface{}
+
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded) != len(results) {
+		t.Fatalf("[!] WriteJSON wrote %d results; want %d", len(decoded), len(results))
+	}
+
+	if decoded[3]["status"] != "failed" || decoded[3]["error"] != "upload failed" {
+		t.Errorf("[!] WriteJSON failed result = %v; want status failed with error", decoded[3])
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+
+	if err := report.WriteJUnit(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	output := buf.String()
+
+	expected := []string{
+		`<testsuites tests="4" failures="1" skipped="2">`,
+		`<testsuite name="git@github.com:org/a.git" tests="2" failures="0" skipped="1"`,
+		`<failure message="upload failed"></failure>`,
+		`<skipped message="skipped: branch filtered out by configuration"></skipped>`,
+	}
+
+	for _, fragment := range expected {
+		if !strings.Contains(output, fragment) {
+			t.Errorf("[!] WriteJUnit output is missing %s\n%s", fragment, output)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package state
+
+import (
+	"encoding/json"
+	"github.com/Lavoaster/cloudsmith-sync/journal"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Package records what a published package version was built from.
+type Package struct {
+	Package     string    `json:"package"`
+	Version     string    `json:"version"`
+	Repository  string    `json:"repository"`
+	Ref         string    `json:"ref"`
+	Commit      string    `json:"commit"`
+	PublishedAt time.Time `json:"publishedAt"`
+}
+
+func (pkg Package) key() string {
+	return key(pkg.Package, pkg.Version)
+}
+
+func key(name, version string) string {
+	return name + ":" + version
+}
+
+type persistedState struct {
+	Packages []Package `json:"packages"`
+}
+
+// change is a logged Put or Delete.
+type change struct {
+	Put    *Package `json:"put,omitempty"`
+	Delete string   `json:"delete,omitempty"`
+}
+
+// Store keeps track of the packages that have been published, so it's known
+// which commit each of them was built from across restarts. Every change is
+// logged to disk as it's made, and it's safe to use from several goroutines.
+type Store struct {
+	journal  *journal.Journal
+	mutex    sync.Mutex
+	packages map[string]Package
+}
+
+// Open loads the state persisted at path, starting an empty one when it
+// doesn't exist yet.
+func Open(path string) (*Store, error) {
+	store := &Store{
+		packages: make(map[string]Package),
+	}
+
+	var persisted persistedState
+
+	j, err := journal.Open(path, &persisted)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range persisted.Packages {
+		store.packages[pkg.key()] = pkg
+	}
+
+	err = j.Replay(func(raw json.RawMessage) error {
+		var logged change
+
+		if err := json.Unmarshal(raw, &logged); err != nil {
+			return err
+		}
+
+		if logged.Put != nil {
+			store.packages[logged.Put.key()] = *logged.Put
+		} else {
+			delete(store.packages, logged.Delete)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	store.journal = j
+
+	return store, nil
+}
+
+// Get returns what a package version was published from.
+func (s *Store) Get(name, version string) (Package, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	pkg, ok := s.packages[key(name, version)]
+
+	return pkg, ok
+}
+
+// Put records a published package version, replacing what it was published
+// from before.
+func (s *Store) Put(pkg Package) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if pkg.PublishedAt.IsZero() {
+		pkg.PublishedAt = time.Now()
+	}
+
+	s.packages[pkg.key()] = pkg
+
+	return s.log(change{Put: &pkg})
+}
+
+// Delete forgets a package version that has been removed.
+func (s *Store) Delete(name, version string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.packages[key(name, version)]; !ok {
+		return nil
+	}
+
+	delete(s.packages, key(name, version))
+
+	return s.log(change{Delete: key(name, version)})
+}
+
+// Packages returns every recorded package version, sorted by name and
+// version.
+func (s *Store) Packages() []Package {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.sortedPackages()
+}
+
+func (s *Store) sortedPackages() []Package {
+	packages := make([]Package, 0, len(s.packages))
+
+	for _, pkg := range s.packages {
+		packages = append(packages, pkg)
+	}
+
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].key() < packages[j].key()
+	})
+
+	return packages
+}
+
+// log writes a change to disk, compacting the log into a new snapshot once
+// it's grown long enough. The caller must hold the mutex.
+func (s *Store) log(logged change) error {
+	if err := s.journal.Append(logged); err != nil {
+		return err
+	}
+
+	if !s.journal.NeedsCompacting(len(s.packages)) {
+		return nil
+	}
+
+	return s.journal.Compact(persistedState{Packages: s.sortedPackages()})
+}
@@ -0,0 +1,64 @@
+package state_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestStorePersistsPackages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-state")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := state.Open(dir + "/state.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := store.Get("org/package", "dev-master"); ok {
+		t.Errorf("[!] Get() found a package in an empty store")
+	}
+
+	packages := []state.Package{
+		{Package: "org/package", Version: "dev-master", Ref: "master", Commit: "aaa"},
+		{Package: "org/package", Version: "dev-master", Ref: "master", Commit: "bbb"},
+		{Package: "org/package", Version: "1.0.0", Ref: "1.0.0", Commit: "ccc"},
+		{Package: "org/other", Version: "dev-develop", Ref: "develop", Commit: "ddd"},
+	}
+
+	for _, pkg := range packages {
+		if err := store.Put(pkg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := store.Delete("org/other", "dev-develop"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := state.Open(dir + "/state.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkg, ok := reopened.Get("org/package", "dev-master")
+
+	if !ok || pkg.Commit != "bbb" || pkg.PublishedAt.IsZero() {
+		t.Errorf("[!] Get(org/package, dev-master) = %+v, %v; want the latest commit", pkg, ok)
+	}
+
+	if _, ok := reopened.Get("org/other", "dev-develop"); ok {
+		t.Errorf("[!] a deleted package was persisted")
+	}
+
+	if len(reopened.Packages()) != 2 {
+		t.Errorf("[!] Packages() returned %d packages; want 2", len(reopened.Packages()))
+	}
+}
@@ -0,0 +1,45 @@
+package sync
+
+import (
+	"errors"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"time"
+)
+
+var ErrPollTimeout = errors.New("timed out waiting for cloudsmith")
+
+// Poll intervals start small, as most changes are processed within seconds,
+// and back off exponentially up to the maximum.
+var initialPollInterval = time.Second
+var maxPollInterval = 30 * time.Second
+
+// poll calls check until it reports done or fails, backing off between calls.
+// ErrPollTimeout is returned when it isn't done within timeout.
+func poll(timeout time.Duration, check func() (bool, error)) error {
+	if timeout <= 0 {
+		timeout = config.DefaultPollTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	interval := initialPollInterval
+
+	for {
+		done, err := check()
+
+		if err != nil || done {
+			return err
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			return ErrPollTimeout
+		}
+
+		time.Sleep(interval)
+
+		interval *= 2
+
+		if interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package sync
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	"sort"
+	"strings"
+	"time"
+)
+
+// publishedVersions are the versions the current refs of the repositories
+// publish, by package name.
+type publishedVersions map[string]map[string]bool
+
+// PruneRepositories deletes the packages of branches, and tags when the
+// prune rules allow it, that no longer exist in the repositories publishing
+// them. Versions are compared across all of the repositories, so a package
+// published from several of them only loses versions none of them have.
+// Refs whose package name can't be read are reported as failures, and the
+// versions they'd publish are kept for every package.
+func (s *Syncer) PruneRepositories(repositories []config.Repository) []report.Result {
+	var results []report.Result
+
+	versions := make(publishedVersions)
+	unreadable := make(map[string]bool)
+	sources := make(map[string]string)
+
+	for i := range repositories {
+		repoCfg := &repositories[i]
+
+		repo, err := s.openRepository(repoCfg)
+
+		if err != nil {
+			results = append(results, s.repositoryFailure(repoCfg, err))
+			continue
+		}
+
+		names, failures := s.collectVersions(repo, versions, unreadable)
+
+		for _, name := range names {
+			if _, ok := sources[name]; !ok {
+				sources[name] = repoCfg.Url
+			}
+		}
+
+		results = append(results, failures...)
+	}
+
+	for version := range unreadable {
+		for _, packageVersions := range versions {
+			packageVersions[version] = true
+		}
+	}
+
+	var names []string
+
+	for name := range versions {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		results = append(results, s.prunePackage(sources[name], name, versions[name])...)
+	}
+
+	return results
+}
+
+// collectVersions adds the package versions published from the refs of a
+// repository, returning the package names found. The versions of refs whose
+// package name can't be read are added to unreadable, with a failure for each
+// of them.
+func (s *Syncer) collectVersions(repo *repository, versions publishedVersions, unreadable map[string]bool) ([]string, []report.Result) {
+	var names []string
+	var failures []report.Result
+
+	for _, ref := range repo.Refs {
+		isBranch := ref.Name().IsBranch()
+
+		if !isBranch && !ref.Name().IsTag() {
+			continue
+		}
+
+		// Nothing is published from refs that don't make a version
+		version, _, err := composer.DeriveVersion(ref.Name().Short(), isBranch)
+
+		if err != nil {
+			continue
+		}
+
+		packageName, err := readPackageName(repo.Repo, ref.Hash())
+
+		if err != nil {
+			unreadable[version] = true
+			failures = append(failures, s.refFailure(repo.Config, ref.Name().Short(), err))
+
+			continue
+		}
+
+		if versions[packageName] == nil {
+			versions[packageName] = make(map[string]bool)
+			names = append(names, packageName)
+		}
+
+		versions[packageName][version] = true
+	}
+
+	return names, failures
+}
+
+// prunePackage deletes the versions of a package that aren't published from
+// any current ref.
+func (s *Syncer) prunePackage(repositoryUrl, name string, versions map[string]bool) []report.Result {
+	started := time.Now()
+
+	pkgs, err := s.Client.ListPackages(s.Config.Owner, s.Config.TargetRepository, "name:"+name+" format:composer")
+
+	if err != nil {
+		s.Logf("Failed to list the packages of %s - %v", name, err)
+
+		return []report.Result{{
+			Repository: repositoryUrl,
+			Package:    name,
+			Status:     report.Failed,
+			Error:      err,
+			Duration:   time.Since(started),
+		}}
+	}
+
+	var results []report.Result
+
+	for _, pkg := range pkgs {
+		// Searching by name also finds packages the name is a part of
+		if pkg.Name != name || versions[pkg.Version] {
+			continue
+		}
+
+		results = append(results, s.pruneVersion(repositoryUrl, pkg))
+	}
+
+	return results
+}
+
+func (s *Syncer) pruneVersion(repositoryUrl string, pkg cloudsmith_api.ModelPackage) report.Result {
+	started := time.Now()
+
+	result := report.Result{
+		Repository:    repositoryUrl,
+		Package:       pkg.Name,
+		Version:       pkg.Version,
+		PackageStatus: pkg.StatusStr,
+	}
+
+	if s.State != nil {
+		if published, ok := s.State.Get(pkg.Name, pkg.Version); ok {
+			result.Ref = published.Ref
+			result.Commit = published.Commit
+		}
+	}
+
+	skip := func(reason string) report.Result {
+		s.Logf("Keeping %s@%s - %s", pkg.Name, pkg.Version, reason)
+
+		result.Status = report.Skipped
+		result.Reason = reason
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	keep, err := s.Config.Prune.Keeps(pkg.Name, pkg.Version)
+
+	if err != nil {
+		result.Status = report.Failed
+		result.Error = err
+
+		return result
+	}
+
+	if keep {
+		return skip("kept by the prune rules")
+	}
+
+	if !isBranchVersion(pkg.Version) && !s.Config.Prune.Tags {
+		return skip("tags aren't pruned")
+	}
+
+	result.Reason = "ref no longer exists"
+
+	if s.DryRun {
+		s.Logf("Would prune %s@%s", pkg.Name, pkg.Version)
+
+		result.Status = report.WouldDelete
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	err = s.Client.DeletePackage(s.Config.Owner, s.Config.TargetRepository, pkg.SlugPerm)
+
+	if err != nil {
+		s.Logf("Failed to prune %s@%s - %v", pkg.Name, pkg.Version, err)
+
+		result.Status = report.Failed
+		result.Error = err
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	s.forgetPackage(pkg.Name, pkg.Version)
+
+	s.Logf("Pruned %s@%s", pkg.Name, pkg.Version)
+
+	result.Status = report.Deleted
+	result.Duration = time.Since(started)
+
+	return result
+}
+
+// isBranchVersion reports whether a version was derived from a branch, which
+// gives dev-name or 1.x-dev versions.
+func isBranchVersion(version string) bool {
+	return strings.HasPrefix(version, "dev-") || strings.HasSuffix(version, "-dev")
+}
+
+// branchFromVersion returns the name of the branch a version was derived from,
+// e.g. release/1.x for dev-release/1.x and 1.x for 1.x-dev.
+func branchFromVersion(version string) string {
+	if strings.HasPrefix(version, "dev-") {
+		return strings.TrimPrefix(version, "dev-")
+	}
+
+	return strings.TrimSuffix(version, "-dev")
+}
@@ -0,0 +1,506 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cache"
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	git2 "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var artifactCommitPattern = regexp.MustCompile(`-([0-9a-f]{40})\.(zip|tar|tar\.gz)$`)
+
+// pendingPackage is an artifact that has been built from a ref and is ready to
+// be published. Refs that shouldn't be published have no artifact, and their
+// result explains why.
+type pendingPackage struct {
+	Result  report.Result
+	Replace bool
+	// Replaces is the package to delete when replacing it by deleting it
+	// first, rather than the completed package of the same version.
+	Replaces     string
+	ArtifactPath string
+	ArtifactMd5  string
+	Started      time.Time
+}
+
+// buildRef builds the artifact to publish for a ref, or reuses the one built
+// before for the same commit and config. With replace the artifact is built to
+// replace the existing package whatever state it's in, rather than only when
+// a branch has moved on.
+func (s *Syncer) buildRef(repo *repository, ref *plumbing.Reference, replace bool) *pendingPackage {
+	isBranch := ref.Name().IsBranch()
+
+	pkg := &pendingPackage{
+		Result: report.Result{
+			Repository: repo.Config.Url,
+			Ref:        ref.Name().Short(),
+			Commit:     ref.Hash().String(),
+		},
+		Started: time.Now(),
+	}
+
+	shouldSync, reason, err := repo.Config.ShouldSyncRef(ref.Name().Short(), isBranch)
+
+	if err != nil {
+		return s.failPackage(pkg, err)
+	}
+
+	if !shouldSync {
+		return s.skipPackage(pkg, report.Skipped, reason)
+	}
+
+	if reason, expired := repo.Expired[pkg.Result.Ref]; expired {
+		return s.skipPackage(pkg, report.Skipped, reason)
+	}
+
+	// composer.json is read from the commit, so nothing has to be checked out
+	// when the artifact is cached
+	composerData, err := readComposerFile(repo.Repo, ref.Hash())
+
+	if err != nil {
+		return s.failPackage(pkg, err)
+	}
+
+	packageName, ok := composerData["name"].(string)
+
+	if !ok {
+		return s.failPackage(pkg, errors.New("composer.json has no package name"))
+	}
+
+	pkg.Result.Package = packageName
+
+	version, normalisedVersion, err := composer.DeriveVersion(pkg.Result.Ref, isBranch)
+
+	if err != nil {
+		return s.skipPackage(pkg, report.Skipped, err.Error())
+	}
+
+	pkg.Result.Version = version
+	if replace {
+		pkg.Replace = true
+	} else if skipped := s.checkPublished(pkg, isBranch); skipped != nil {
+		return skipped
+	}
+
+	var source *composer.Source
+	var sourceUrl string
+
+	if repo.Config.PublishSource {
+		sourceUrl = repo.Config.Url
+		source = &composer.Source{
+			Url:       repo.Config.Url,
+			Type:      "git",
+			Reference: pkg.Result.Commit,
+		}
+	}
+
+	namespace, name, err := splitPackageName(packageName)
+
+	if err != nil {
+		return s.failPackage(pkg, err)
+	}
+
+	archiveFormat := repo.Config.ArchiveFormat
+
+	if archiveFormat == "" {
+		archiveFormat = config.ArchiveZip
+	}
+
+	key := cache.Key(pkg.Result.Commit, version, normalisedVersion, sourceUrl, archiveFormat)
+
+	if s.Cache != nil && !s.IgnoreCache {
+		if entry, ok := s.Cache.Get(key); ok {
+			s.Logf("Reusing the cached artifact of %s@%s", packageName, version)
+
+			pkg.ArtifactPath = entry.Path
+			pkg.ArtifactMd5 = entry.Md5
+
+			return pkg
+		}
+	}
+
+	// Each artifact gets a directory of its own, so refs pointing at the same
+	// commit don't overwrite each other while keeping the uploaded file name
+	artifactName := fmt.Sprintf("%v-%v-%v.%v", namespace, name, pkg.Result.Commit, archiveFormat)
+	artifactPath := s.Config.GetArtifactPath(key + "/" + artifactName)
+
+	checksum, err := s.createArtifact(repo, ref, composerData, version, normalisedVersion, source, artifactPath)
+
+	if err != nil {
+		os.RemoveAll(filepath.Dir(artifactPath))
+
+		return s.failPackage(pkg, err)
+	}
+
+	if s.Cache != nil {
+		err = s.Cache.Put(cache.Entry{
+			Key:        key,
+			Repository: repo.Config.Url,
+			Ref:        pkg.Result.Ref,
+			Branch:     isBranch,
+			Commit:     pkg.Result.Commit,
+			Package:    packageName,
+			Version:    version,
+			Path:       artifactPath,
+			Md5:        checksum,
+		})
+
+		if err != nil {
+			s.Logf("Failed to cache the artifact of %s@%s - %v", packageName, version, err)
+		}
+	}
+
+	pkg.ArtifactPath = artifactPath
+	pkg.ArtifactMd5 = checksum
+
+	return pkg
+}
+
+// checkPublished looks for an existing package of the version, returning the
+// skipped or failed package when it shouldn't be published again. Branches
+// that have moved on since they were published are marked to replace it.
+func (s *Syncer) checkPublished(pkg *pendingPackage, isBranch bool) *pendingPackage {
+	exists, err := s.Client.PackageExists(s.Config.Owner, s.Config.TargetRepository, pkg.Result.Package, pkg.Result.Version)
+
+	if err != nil {
+		return s.failPackage(pkg, err)
+	}
+
+	if !exists {
+		return nil
+	}
+
+	// Tags are immutable, while branches get re-published when they've moved
+	// on
+	if !isBranch {
+		return s.skipPackage(pkg, report.AlreadyExists, "package version already exists")
+	}
+
+	publishedCommit, err := s.publishedFrom(pkg.Result)
+
+	if err != nil {
+		return s.failPackage(pkg, err)
+	}
+
+	if publishedCommit == pkg.Result.Commit {
+		return s.skipPackage(pkg, report.AlreadyExists, "branch hasn't changed since it was published")
+	}
+
+	pkg.Replace = true
+
+	return nil
+}
+
+// createArtifact builds the artifact for a ref, either from a checkout of the
+// ref or straight from its tree depending on the configured build mode. It
+// returns the artifacts md5 checksum.
+func (s *Syncer) createArtifact(repo *repository, ref *plumbing.Reference, composerData composer.ComposerFile, version, normalisedVersion string, source *composer.Source, artifactPath string) (string, error) {
+	err := os.MkdirAll(filepath.Dir(artifactPath), 0755)
+
+	if err != nil {
+		return "", err
+	}
+
+	if s.Config.BuildMode == config.BuildModeTree {
+		composerJson, err := composer.MutateComposerData(composerData, version, normalisedVersion, source)
+
+		if err != nil {
+			return "", err
+		}
+
+		return git.CreateArtifactFromCommit(repo.Repo, ref.Hash(), artifactPath, composerJson)
+	}
+
+	commit, err := git.ResolveCommit(repo.Repo, ref.Hash())
+
+	if err != nil {
+		return "", err
+	}
+
+	err = checkout(repo, ref)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer repo.Worktree.Reset(&git2.ResetOptions{
+		Mode: git2.HardReset,
+	})
+
+	// Mutate composer.json file
+	err = composer.MutateComposerFile(repo.Path, version, normalisedVersion, source)
+
+	if err != nil {
+		return "", err
+	}
+
+	// Create archive file
+	return git.CreateArtifactFromRepository(repo.Path, artifactPath, commit.Committer.When)
+}
+
+// publish uploads a built artifact to Cloudsmith, replacing the existing
+// package for branches.
+func (s *Syncer) publish(pkg *pendingPackage) report.Result {
+	result := pkg.Result
+
+	s.Logf("Processing %s@%s...", result.Package, result.Version)
+
+	uploaded, err := s.replaceAndUpload(pkg)
+
+	if err == nil && uploaded != nil {
+		result.PackageStatus = uploaded.StatusStr
+
+		if s.Wait {
+			err = s.waitForProcessing(&result, uploaded)
+		}
+	}
+
+	result.Duration = time.Since(pkg.Started)
+
+	if err != nil {
+		s.Logf("Failed to publish %s@%s - %v", result.Package, result.Version, err)
+
+		result.Status = report.Failed
+		result.Error = err
+
+		return result
+	}
+
+	if s.DryRun {
+		s.Logf("Would publish %s@%s", result.Package, result.Version)
+
+		result.Status = report.WouldPublish
+
+		return result
+	}
+
+	s.Logf("Published %s@%s", result.Package, result.Version)
+
+	result.Status = report.Published
+
+	s.recordPackage(result)
+
+	return result
+}
+
+// waitForProcessing polls an uploaded package until Cloudsmith has finished
+// processing it, updating the result with its status. Packages that are
+// still being processed when the wait times out are left to it.
+func (s *Syncer) waitForProcessing(result *report.Result, uploaded *cloudsmith_api.ModelPackage) error {
+	s.Logf("Waiting for Cloudsmith to process %s@%s...", result.Package, result.Version)
+
+	var status *cloudsmith_api.PackageStatus
+
+	err := poll(s.Config.PollTimeout, func() (bool, error) {
+		var err error
+
+		status, err = s.Client.PackageStatus(s.Config.Owner, s.Config.TargetRepository, uploaded.SlugPerm)
+
+		if err != nil {
+			return false, err
+		}
+
+		return status.IsSyncCompleted || status.IsSyncFailed, nil
+	})
+
+	if status != nil {
+		result.PackageStatus = status.StatusStr
+	}
+
+	if err == ErrPollTimeout {
+		result.Reason = "cloudsmith was still processing the package when the wait timed out"
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if status.IsSyncFailed {
+		return errors.New("cloudsmith failed to process the package: " + status.StatusReason)
+	}
+
+	return nil
+}
+
+// replaceAndUpload uploads an artifact, replacing the existing package for
+// branches. By default the new package is republished over the old one, so
+// the branch stays installable the whole time.
+func (s *Syncer) replaceAndUpload(pkg *pendingPackage) (*cloudsmith_api.ModelPackage, error) {
+	if s.DryRun {
+		return nil, nil
+	}
+
+	owner := s.Config.Owner
+	targetRepository := s.Config.TargetRepository
+	republish := pkg.Replace
+
+	if pkg.Replace && s.Config.ReplaceStrategy == config.ReplaceDelete {
+		republish = false
+
+		var err error
+
+		if pkg.Replaces != "" {
+			err = s.Client.DeletePackage(owner, targetRepository, pkg.Replaces)
+		} else {
+			err = s.Client.DeletePackageIfExists(owner, targetRepository, pkg.Result.Package, pkg.Result.Version)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		err = poll(s.Config.PollTimeout, func() (bool, error) {
+			exists, err := s.Client.RemoteCheckPackageExists(owner, targetRepository, pkg.Result.Package, pkg.Result.Version)
+
+			return !exists, err
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Upload archive to cloudsmith
+	return s.Client.UploadComposerPackage(owner, targetRepository, cloudsmith.Artifact{
+		Path: pkg.ArtifactPath,
+		Md5:  pkg.ArtifactMd5,
+	}, republish)
+}
+
+// publishedFrom returns the commit a package version was last published from,
+// or an empty string when it isn't known. Packages that aren't in the state
+// store yet, like the ones published before it existed, are recognised by the
+// commit in their artifacts file name.
+func (s *Syncer) publishedFrom(result report.Result) (string, error) {
+	if s.State != nil {
+		if published, ok := s.State.Get(result.Package, result.Version); ok {
+			return published.Commit, nil
+		}
+	}
+
+	filename, err := s.Client.PackageFilename(s.Config.Owner, s.Config.TargetRepository, result.Package, result.Version)
+
+	if err != nil {
+		return "", err
+	}
+
+	commit := commitFromFilename(filename)
+
+	if commit == result.Commit {
+		s.recordPackage(result)
+	}
+
+	return commit, nil
+}
+
+// commitFromFilename extracts the commit from an artifact named
+// namespace-name-commit.zip (or .tar, .tar.gz).
+func commitFromFilename(filename string) string {
+	matches := artifactCommitPattern.FindStringSubmatch(filename)
+
+	if matches == nil {
+		return ""
+	}
+
+	return matches[1]
+}
+
+func (s *Syncer) recordPackage(result report.Result) {
+	if s.State == nil {
+		return
+	}
+
+	err := s.State.Put(state.Package{
+		Package:    result.Package,
+		Version:    result.Version,
+		Repository: result.Repository,
+		Ref:        result.Ref,
+		Commit:     result.Commit,
+	})
+
+	if err != nil {
+		s.Logf("Failed to record %s@%s - %v", result.Package, result.Version, err)
+	}
+}
+
+func (s *Syncer) forgetPackage(name, version string) {
+	if s.State == nil {
+		return
+	}
+
+	if err := s.State.Delete(name, version); err != nil {
+		s.Logf("Failed to forget %s@%s - %v", name, version, err)
+	}
+}
+
+func (s *Syncer) skipPackage(pkg *pendingPackage, status report.Status, reason string) *pendingPackage {
+	s.Logf("Skipping %s - %s", pkg.Result.Ref, reason)
+
+	pkg.Result.Status = status
+	pkg.Result.Reason = reason
+	pkg.Result.Duration = time.Since(pkg.Started)
+
+	return pkg
+}
+
+func (s *Syncer) failPackage(pkg *pendingPackage, err error) *pendingPackage {
+	s.Logf("Failed to process %s - %v", pkg.Result.Ref, err)
+
+	pkg.Result.Status = report.Failed
+	pkg.Result.Error = err
+	pkg.Result.Duration = time.Since(pkg.Started)
+
+	return pkg
+}
+
+func checkout(repo *repository, ref *plumbing.Reference) error {
+	var err error
+
+	if ref.Name().IsBranch() {
+		_, err = git.CheckoutBranch(repo.Repo, repo.Worktree, ref)
+	} else {
+		_, err = git.CheckoutTag(repo.Repo, repo.Worktree, ref)
+	}
+
+	return err
+}
+
+func readComposerFile(repo *git2.Repository, hash plumbing.Hash) (composer.ComposerFile, error) {
+	raw, err := git.ReadFile(repo, hash, "composer.json")
+
+	if err != nil {
+		return nil, err
+	}
+
+	return composer.Parse(raw)
+}
+
+func readPackageName(repo *git2.Repository, hash plumbing.Hash) (string, error) {
+	composerData, err := readComposerFile(repo, hash)
+
+	if err != nil {
+		return "", err
+	}
+
+	packageName, ok := composerData["name"].(string)
+
+	if !ok {
+		return "", errors.New("composer.json has no package name")
+	}
+
+	return packageName, nil
+}
@@ -0,0 +1,319 @@
+package sync
+
+import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	"sort"
+	"time"
+)
+
+// retainedVersion is a version of a package that retention rules are
+// evaluated against.
+type retainedVersion struct {
+	Version    string
+	Normalised string
+	IsBranch   bool
+	// Ref is the branch or tag the version is published from, it's empty
+	// when the ref no longer exists.
+	Ref string
+	// UpdatedAt is when the ref was last committed to, or when the package
+	// was uploaded when the ref no longer exists.
+	UpdatedAt time.Time
+}
+
+// ApplyRetention deletes the packages of repositories with retention rules
+// that the rules no longer keep. The versions on Cloudsmith are evaluated, so
+// packages of refs that have been deleted count too, using the commit dates
+// of the refs that still exist and the upload dates of the ones that don't.
+func (s *Syncer) ApplyRetention(repositories []config.Repository) []report.Result {
+	var results []report.Result
+
+	for i := range repositories {
+		repoCfg := &repositories[i]
+
+		if repoCfg.Retention == nil {
+			continue
+		}
+
+		repo, err := s.openRepository(repoCfg)
+
+		if err != nil {
+			results = append(results, s.repositoryFailure(repoCfg, err))
+			continue
+		}
+
+		refVersions := collectRetainedVersions(repo)
+
+		var names []string
+
+		for name := range refVersions {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			results = append(results, s.applyPackageRetention(repoCfg, name, refVersions[name])...)
+		}
+	}
+
+	return results
+}
+
+// applyPackageRetention deletes the versions of a package on Cloudsmith that
+// the retention rules of the repository publishing it don't keep.
+func (s *Syncer) applyPackageRetention(repoCfg *config.Repository, name string, refVersions []retainedVersion) []report.Result {
+	started := time.Now()
+
+	pkgs, err := s.Client.ListPackages(s.Config.Owner, s.Config.TargetRepository, "name:"+name+" format:composer")
+
+	if err != nil {
+		s.Logf("Failed to list the packages of %s - %v", name, err)
+
+		return []report.Result{{
+			Repository: repoCfg.Url,
+			Package:    name,
+			Status:     report.Failed,
+			Error:      err,
+			Duration:   time.Since(started),
+		}}
+	}
+
+	fromRefs := make(map[string]retainedVersion)
+
+	for _, version := range refVersions {
+		fromRefs[version.Version] = version
+	}
+
+	var published []cloudsmith_api.ModelPackage
+	var versions []retainedVersion
+
+	for _, pkg := range pkgs {
+		// Searching by name also finds packages the name is a part of
+		if pkg.Name != name {
+			continue
+		}
+
+		version, ok := fromRefs[pkg.Version]
+
+		if !ok {
+			version = packageRetainedVersion(pkg)
+		}
+
+		published = append(published, pkg)
+		versions = append(versions, version)
+	}
+
+	expired, err := expiredVersions(*repoCfg.Retention, versions, time.Now())
+
+	if err != nil {
+		return []report.Result{s.repositoryFailure(repoCfg, err)}
+	}
+
+	var results []report.Result
+
+	for i, pkg := range published {
+		if reason, ok := expired[pkg.Version]; ok {
+			results = append(results, s.deleteExpired(repoCfg.Url, pkg, versions[i].Ref, reason))
+		}
+	}
+
+	return results
+}
+
+func (s *Syncer) deleteExpired(repositoryUrl string, pkg cloudsmith_api.ModelPackage, ref, reason string) report.Result {
+	started := time.Now()
+
+	result := report.Result{
+		Repository:    repositoryUrl,
+		Ref:           ref,
+		Package:       pkg.Name,
+		Version:       pkg.Version,
+		PackageStatus: pkg.StatusStr,
+	}
+
+	if s.State != nil {
+		if published, ok := s.State.Get(pkg.Name, pkg.Version); ok {
+			result.Commit = published.Commit
+		}
+	}
+
+	result.Reason = reason
+
+	if s.DryRun {
+		s.Logf("Would delete %s@%s - %s", pkg.Name, pkg.Version, reason)
+
+		result.Status = report.WouldDelete
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	err := s.Client.DeletePackage(s.Config.Owner, s.Config.TargetRepository, pkg.SlugPerm)
+
+	if err != nil {
+		s.Logf("Failed to delete %s@%s - %v", pkg.Name, pkg.Version, err)
+
+		result.Status = report.Failed
+		result.Error = err
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	s.forgetPackage(pkg.Name, pkg.Version)
+
+	s.Logf("Deleted %s@%s - %s", pkg.Name, pkg.Version, reason)
+
+	result.Status = report.Deleted
+	result.Duration = time.Since(started)
+
+	return result
+}
+
+// expiredRefs returns the branches and tags of a repository its retention
+// rules don't keep, with the reason why, so they aren't published again.
+func expiredRefs(repo *repository) (map[string]string, error) {
+	expiredRefs := make(map[string]string)
+
+	for _, versions := range collectRetainedVersions(repo) {
+		expired, err := expiredVersions(*repo.Config.Retention, versions, time.Now())
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, version := range versions {
+			if reason, ok := expired[version.Version]; ok {
+				expiredRefs[version.Ref] = reason
+			}
+		}
+	}
+
+	return expiredRefs, nil
+}
+
+// collectRetainedVersions returns the versions the refs of a repository
+// publish, by package name.
+func collectRetainedVersions(repo *repository) map[string][]retainedVersion {
+	versions := make(map[string][]retainedVersion)
+
+	for _, ref := range repo.Refs {
+		isBranch := ref.Name().IsBranch()
+
+		if !isBranch && !ref.Name().IsTag() {
+			continue
+		}
+
+		packageName, err := readPackageName(repo.Repo, ref.Hash())
+
+		if err != nil {
+			continue
+		}
+
+		version, normalisedVersion, err := composer.DeriveVersion(ref.Name().Short(), isBranch)
+
+		if err != nil {
+			continue
+		}
+
+		commit, err := git.ResolveCommit(repo.Repo, ref.Hash())
+
+		if err != nil {
+			continue
+		}
+
+		versions[packageName] = append(versions[packageName], retainedVersion{
+			Version:    version,
+			Normalised: normalisedVersion,
+			IsBranch:   isBranch,
+			Ref:        ref.Name().Short(),
+			UpdatedAt:  commit.Committer.When,
+		})
+	}
+
+	return versions
+}
+
+// packageRetainedVersion describes a package whose ref no longer exists.
+func packageRetainedVersion(pkg cloudsmith_api.ModelPackage) retainedVersion {
+	version := retainedVersion{
+		Version:  pkg.Version,
+		IsBranch: isBranchVersion(pkg.Version),
+	}
+
+	if normalised, err := composer.NormaliseVersion(pkg.Version, ""); err == nil {
+		version.Normalised = normalised
+	}
+
+	if uploadedAt, err := time.Parse(time.RFC3339, pkg.UploadedAt); err == nil {
+		version.UpdatedAt = uploadedAt
+	}
+
+	return version
+}
+
+// expiredVersions returns the versions of a package that the retention rules
+// don't keep, with the reason why.
+func expiredVersions(rules config.RetentionRules, versions []retainedVersion, now time.Time) (map[string]string, error) {
+	expired := make(map[string]string)
+
+	if rules.BranchDays > 0 {
+		maxAge := time.Duration(rules.BranchDays) * 24 * time.Hour
+
+		for _, version := range versions {
+			if !version.IsBranch || version.UpdatedAt.IsZero() || now.Sub(version.UpdatedAt) <= maxAge {
+				continue
+			}
+
+			// Branches whose ref is gone are matched by the branch name their
+			// version was derived from
+			branch := version.Ref
+
+			if branch == "" {
+				branch = branchFromVersion(version.Version)
+			}
+
+			keep, err := rules.KeepsBranch(branch)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !keep {
+				expired[version.Version] = fmt.Sprintf("branch hasn't been updated in %d days", rules.BranchDays)
+			}
+		}
+	}
+
+	if rules.PreReleasesPerMinor > 0 {
+		preReleases := make(map[string][]retainedVersion)
+
+		for _, version := range versions {
+			if !version.IsBranch && composer.IsPreRelease(version.Normalised) {
+				line := composer.MinorLine(version.Normalised)
+				preReleases[line] = append(preReleases[line], version)
+			}
+		}
+
+		for line, lineVersions := range preReleases {
+			sort.SliceStable(lineVersions, func(i, j int) bool {
+				return composer.CompareVersions(lineVersions[i].Normalised, lineVersions[j].Normalised) > 0
+			})
+
+			for i, version := range lineVersions {
+				if i < rules.PreReleasesPerMinor {
+					continue
+				}
+
+				expired[version.Version] = fmt.Sprintf("only the newest %d pre-releases of %s are kept", rules.PreReleasesPerMinor, line)
+			}
+		}
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,219 @@
+package sync
+
+import (
+	"errors"
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"time"
+)
+
+// RetryOptions pick which failed packages RetryFailed retries, and how.
+type RetryOptions struct {
+	// Names are patterns the package name has to match, all packages are
+	// retried when empty.
+	Names []string
+	// MaxAge leaves out packages uploaded longer ago than this, zero means no
+	// limit.
+	MaxAge time.Duration
+	// Rebuild builds the packages again from git and uploads them in place
+	// of the failed ones, rather than asking Cloudsmith to resync them.
+	Rebuild bool
+}
+
+// packageSource is the ref of a configured repository a package version is
+// built from.
+type packageSource struct {
+	Repo *repository
+	Ref  *plumbing.Reference
+}
+
+// RetryFailed retries every failed composer package on Cloudsmith that
+// matches the options, returning a result per package.
+func (s *Syncer) RetryFailed(options RetryOptions) ([]report.Result, error) {
+	pkgs, err := s.Client.ListPackages(s.Config.Owner, s.Config.TargetRepository, "status:failed format:composer")
+
+	if err != nil {
+		return nil, err
+	}
+
+	names := config.RefFilter{Include: options.Names}
+
+	var results []report.Result
+	var sources map[string]packageSource
+
+	for _, pkg := range pkgs {
+		matches, err := names.Matches(pkg.Name)
+
+		if err != nil {
+			return results, err
+		}
+
+		if !matches || !uploadedWithin(pkg, options.MaxAge) {
+			continue
+		}
+
+		if options.Rebuild {
+			if sources == nil {
+				sources = s.indexPackageSources()
+			}
+
+			results = append(results, s.rebuildPackage(pkg, sources))
+		} else {
+			results = append(results, s.resyncPackage(pkg))
+		}
+	}
+
+	return results, nil
+}
+
+// resyncPackage asks Cloudsmith to process a failed package again.
+func (s *Syncer) resyncPackage(pkg cloudsmith_api.ModelPackage) report.Result {
+	started := time.Now()
+	result := s.failedPackageResult(pkg)
+
+	if s.DryRun {
+		s.Logf("Would resync %s@%s", pkg.Name, pkg.Version)
+
+		result.Status = report.WouldResync
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	err := s.Client.ResyncPackage(s.Config.Owner, s.Config.TargetRepository, pkg.SlugPerm)
+
+	if err != nil {
+		s.Logf("Failed to resync %s@%s - %v", pkg.Name, pkg.Version, err)
+
+		result.Status = report.Failed
+		result.Error = err
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	s.Logf("Resynced %s@%s", pkg.Name, pkg.Version)
+
+	result.Status = report.Resynced
+	result.Duration = time.Since(started)
+
+	return result
+}
+
+// rebuildPackage builds the ref a failed package was built from again, and
+// uploads it in place of the failed package. Nothing is replaced unless the
+// new artifact could be built.
+func (s *Syncer) rebuildPackage(pkg cloudsmith_api.ModelPackage, sources map[string]packageSource) report.Result {
+	started := time.Now()
+	result := s.failedPackageResult(pkg)
+
+	source, ok := sources[pkg.Name+"@"+pkg.Version]
+
+	if !ok {
+		err := errors.New("no configured repository has a ref that " + pkg.Name + "@" + pkg.Version + " is built from")
+
+		s.Logf("Failed to rebuild %s@%s - %v", pkg.Name, pkg.Version, err)
+
+		result.Status = report.Failed
+		result.Error = err
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	pending := s.buildRef(source.Repo, source.Ref, true)
+	s.flushCache()
+
+	if pending.ArtifactPath == "" {
+		return pending.Result
+	}
+
+	// Looking packages up by version only finds completed ones
+	pending.Replaces = pkg.SlugPerm
+
+	return s.publish(pending)
+}
+
+// indexPackageSources opens every configured repository once, and finds the
+// ref each package version is built from, keyed by name@version.
+func (s *Syncer) indexPackageSources() map[string]packageSource {
+	sources := make(map[string]packageSource)
+
+	for i := range s.Config.Repositories {
+		repoCfg := &s.Config.Repositories[i]
+
+		repo, err := s.openRepository(repoCfg)
+
+		if err != nil {
+			s.Logf("Failed to open %s - %v", repoCfg.Url, err)
+			continue
+		}
+
+		for _, ref := range repo.Refs {
+			isBranch := ref.Name().IsBranch()
+
+			if !isBranch && !ref.Name().IsTag() {
+				continue
+			}
+
+			version, _, err := composer.DeriveVersion(ref.Name().Short(), isBranch)
+
+			if err != nil {
+				continue
+			}
+
+			packageName, err := readPackageName(repo.Repo, ref.Hash())
+
+			if err != nil {
+				continue
+			}
+
+			// The first repository publishing a version is the one used
+			if _, ok := sources[packageName+"@"+version]; !ok {
+				sources[packageName+"@"+version] = packageSource{Repo: repo, Ref: ref}
+			}
+		}
+	}
+
+	return sources
+}
+
+// failedPackageResult starts the result of retrying a package, with the
+// repository and ref it was published from when they're known.
+func (s *Syncer) failedPackageResult(pkg cloudsmith_api.ModelPackage) report.Result {
+	result := report.Result{
+		Package:       pkg.Name,
+		Version:       pkg.Version,
+		Reason:        pkg.StatusReason,
+		PackageStatus: pkg.StatusStr,
+	}
+
+	if s.State != nil {
+		if published, ok := s.State.Get(pkg.Name, pkg.Version); ok {
+			result.Repository = published.Repository
+			result.Ref = published.Ref
+			result.Commit = published.Commit
+		}
+	}
+
+	return result
+}
+
+// uploadedWithin reports whether a package was uploaded within maxAge,
+// packages without an upload time are always included.
+func uploadedWithin(pkg cloudsmith_api.ModelPackage, maxAge time.Duration) bool {
+	if maxAge <= 0 {
+		return true
+	}
+
+	uploadedAt, err := time.Parse(time.RFC3339, pkg.UploadedAt)
+
+	if err != nil {
+		return true
+	}
+
+	return time.Since(uploadedAt) <= maxAge
+}
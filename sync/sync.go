@@ -0,0 +1,399 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/cache"
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith"
+	"github.com/Lavoaster/cloudsmith-sync/composer"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	git2 "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"sort"
+	"strings"
+	sync2 "sync"
+	"time"
+)
+
+const (
+	TargetTags     = "tags"
+	TargetBranches = "branches"
+	TargetBoth     = "both"
+)
+
+// Syncer publishes the branches and tags of configured repositories as
+// composer packages on Cloudsmith. It is shared by the `run` command and the
+// webhook server so both behave the same way.
+type Syncer struct {
+	Config *config.Config
+	Client cloudsmith.Registry
+	Git    *git.Backend
+	// Cache reuses artifacts that were built before, it may be nil.
+	Cache *cache.Index
+	// IgnoreCache builds every artifact again, replacing any cached ones.
+	IgnoreCache bool
+	// State records the commit every package was published from, it may be
+	// nil.
+	State *state.Store
+
+	// Target limits SyncRepository to tags, branches or both.
+	Target string
+	// Concurrency bounds how many repositories, and uploads within each
+	// repository, are processed at once.
+	Concurrency int
+	// DryRun builds artifacts without changing anything on Cloudsmith.
+	DryRun bool
+	// Wait polls uploaded packages until Cloudsmith has processed them, up
+	// to the configured PollTimeout, so failures show up in the results.
+	Wait bool
+	// Logf receives progress messages, it may be called from several
+	// goroutines at once.
+	Logf func(format string, a ...interface{})
+}
+
+// repository is a configured repository that has been cloned or fetched.
+type repository struct {
+	Config   *config.Repository
+	Path     string
+	Repo     *git2.Repository
+	Worktree *git2.Worktree
+	Refs     []*plumbing.Reference
+	// Expired are the refs the retention rules of the repository don't keep,
+	// with the reason why.
+	Expired map[string]string
+}
+
+func NewSyncer(cfg *config.Config, client cloudsmith.Registry, backend *git.Backend) *Syncer {
+	return &Syncer{
+		Config:      cfg,
+		Client:      client,
+		Git:         backend,
+		Target:      TargetBoth,
+		Concurrency: 1,
+		Logf:        func(format string, a ...interface{}) {},
+	}
+}
+
+// SyncRepositories syncs all of the given repositories, using up to
+// Concurrency workers. A failing repository doesn't stop the others.
+func (s *Syncer) SyncRepositories(repositories []config.Repository) []report.Result {
+	repositoryResults := make([][]report.Result, len(repositories))
+	jobs := make(chan int)
+
+	var wg sync2.WaitGroup
+
+	for i := 0; i < s.concurrency(); i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for index := range jobs {
+				repositoryResults[index] = s.SyncRepository(&repositories[index])
+			}
+		}()
+	}
+
+	for index := range repositories {
+		jobs <- index
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	var results []report.Result
+
+	for _, repositoryResult := range repositoryResults {
+		results = append(results, repositoryResult...)
+	}
+
+	return results
+}
+
+// SyncRepository publishes every branch and tag of a repository that matches
+// the target and the repositories filtering rules.
+func (s *Syncer) SyncRepository(repoCfg *config.Repository) []report.Result {
+	var results []report.Result
+	var resultsMutex sync2.Mutex
+
+	addResult := func(result report.Result) {
+		resultsMutex.Lock()
+		defer resultsMutex.Unlock()
+
+		results = append(results, result)
+	}
+
+	s.Logf("Processing repository: %s", repoCfg.Url)
+
+	repo, err := s.openRepository(repoCfg)
+
+	if err != nil {
+		return []report.Result{s.repositoryFailure(repoCfg, err)}
+	}
+
+	// The worktree can only have one ref checked out at a time, so artifacts
+	// are built one by one while publishing them happens in parallel.
+	uploads := make(chan struct{}, s.concurrency())
+
+	var wg sync2.WaitGroup
+
+	for _, ref := range repo.Refs {
+		isBranch := ref.Name().IsBranch()
+
+		if !isBranch && !ref.Name().IsTag() {
+			continue
+		}
+
+		if (isBranch && s.Target == TargetTags) || (!isBranch && s.Target == TargetBranches) {
+			continue
+		}
+
+		pkg := s.buildRef(repo, ref, false)
+
+		if pkg.ArtifactPath == "" {
+			addResult(pkg.Result)
+			continue
+		}
+
+		wg.Add(1)
+		uploads <- struct{}{}
+
+		go func() {
+			defer wg.Done()
+			defer func() { <-uploads }()
+
+			addResult(s.publish(pkg))
+		}()
+	}
+
+	wg.Wait()
+
+	s.flushCache()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Ref < results[j].Ref
+	})
+
+	return results
+}
+
+// SyncRef publishes a single branch or tag, given either its full reference
+// name (refs/heads/master) or its short name. When the ref no longer exists on
+// the remote, the package that was published from it is removed instead.
+func (s *Syncer) SyncRef(repoCfg *config.Repository, name string) report.Result {
+	repo, err := s.openRepository(repoCfg)
+
+	if err != nil {
+		return s.repositoryFailure(repoCfg, err)
+	}
+
+	for _, ref := range repo.Refs {
+		if !ref.Name().IsBranch() && !ref.Name().IsTag() {
+			continue
+		}
+
+		if ref.Name().String() == name || ref.Name().Short() == name {
+			pkg := s.buildRef(repo, ref, false)
+			s.flushCache()
+
+			if pkg.ArtifactPath == "" {
+				return pkg.Result
+			}
+
+			return s.publish(pkg)
+		}
+	}
+
+	refName := plumbing.ReferenceName(name)
+
+	if !refName.IsBranch() && !refName.IsTag() {
+		return s.refFailure(repoCfg, name, errors.New("ref not found on remote"))
+	}
+
+	return s.removeRef(repo, refName)
+}
+
+func (s *Syncer) openRepository(repoCfg *config.Repository) (*repository, error) {
+	repoDir, err := git.GitUrlToDirectory(repoCfg.Url)
+
+	if err != nil {
+		return nil, err
+	}
+
+	repoPath := s.Config.GetRepoPath(repoDir)
+
+	repo, err := s.Git.CloneOrOpenAndUpdate(repoCfg, repoPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := s.Git.ListRefs(repoCfg, repo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	worktree, err := repo.Worktree()
+
+	if err != nil {
+		return nil, err
+	}
+
+	opened := &repository{
+		Config:   repoCfg,
+		Path:     repoPath,
+		Repo:     repo,
+		Worktree: worktree,
+		Refs:     refs,
+	}
+
+	if repoCfg.Retention != nil {
+		opened.Expired, err = expiredRefs(opened)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return opened, nil
+}
+
+// removeRef deletes the package that was published from a ref which has been
+// deleted from the remote.
+func (s *Syncer) removeRef(repo *repository, refName plumbing.ReferenceName) report.Result {
+	started := time.Now()
+	isBranch := refName.IsBranch()
+	shortName := refName.Short()
+
+	result := report.Result{
+		Repository: repo.Config.Url,
+		Ref:        shortName,
+	}
+
+	fail := func(err error) report.Result {
+		failure := s.refFailure(repo.Config, shortName, err)
+		failure.Package = result.Package
+		failure.Version = result.Version
+		failure.Duration = time.Since(started)
+
+		return failure
+	}
+
+	packageName, err := packageNameForDeletedRef(repo, refName)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	result.Package = packageName
+
+	version, _, err := composer.DeriveVersion(shortName, isBranch)
+
+	if err != nil {
+		result.Status = report.Skipped
+		result.Reason = err.Error()
+
+		return result
+	}
+
+	result.Version = version
+	result.Reason = "ref no longer exists"
+
+	if s.DryRun {
+		s.Logf("Would remove %s@%s", packageName, version)
+
+		result.Status = report.WouldDelete
+		result.Duration = time.Since(started)
+
+		return result
+	}
+
+	err = s.Client.DeletePackageIfExists(s.Config.Owner, s.Config.TargetRepository, packageName, version)
+
+	if err != nil {
+		return fail(err)
+	}
+
+	s.forgetPackage(packageName, version)
+
+	s.Logf("Removed %s@%s", packageName, version)
+
+	result.Status = report.Deleted
+	result.Duration = time.Since(started)
+
+	return result
+}
+
+// packageNameForDeletedRef reads the package name from the last known commit
+// of a deleted ref, falling back to the repositories HEAD.
+func packageNameForDeletedRef(repo *repository, refName plumbing.ReferenceName) (string, error) {
+	for _, candidate := range []plumbing.ReferenceName{refName, plumbing.HEAD} {
+		ref, err := repo.Repo.Reference(candidate, true)
+
+		if err != nil {
+			continue
+		}
+
+		packageName, err := readPackageName(repo.Repo, ref.Hash())
+
+		if err == nil {
+			return packageName, nil
+		}
+	}
+
+	return "", errors.New("unable to determine package name for " + refName.String())
+}
+
+// flushCache records which cached artifacts have been used.
+func (s *Syncer) flushCache() {
+	if s.Cache == nil {
+		return
+	}
+
+	if err := s.Cache.Flush(); err != nil {
+		s.Logf("Failed to record the use of cached artifacts - %v", err)
+	}
+}
+
+func (s *Syncer) repositoryFailure(repoCfg *config.Repository, err error) report.Result {
+	s.Logf("Failed to sync repository %s - %v", repoCfg.Url, err)
+
+	return report.Result{
+		Repository: repoCfg.Url,
+		Status:     report.Failed,
+		Error:      err,
+	}
+}
+
+func (s *Syncer) refFailure(repoCfg *config.Repository, ref string, err error) report.Result {
+	s.Logf("Failed to sync %s of %s - %v", ref, repoCfg.Url, err)
+
+	return report.Result{
+		Repository: repoCfg.Url,
+		Ref:        ref,
+		Status:     report.Failed,
+		Error:      err,
+	}
+}
+
+func (s *Syncer) concurrency() int {
+	if s.Concurrency < 1 {
+		return 1
+	}
+
+	return s.Concurrency
+}
+
+func splitPackageName(packageName string) (namespace, name string, err error) {
+	parts := strings.Split(packageName, "/")
+
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid package name %s", packageName)
+	}
+
+	return parts[0], parts[1], nil
+}
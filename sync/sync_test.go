@@ -0,0 +1,793 @@
+package sync_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith/cloudsmithtest"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/state"
+	"github.com/Lavoaster/cloudsmith-sync/sync"
+	"github.com/cloudsmith-io/cloudsmith-api/bindings/go/src"
+	git2 "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// remote is a local git repository that repositories are synced from.
+type remote struct {
+	t        *testing.T
+	Path     string
+	Repo     *git2.Repository
+	Worktree *git2.Worktree
+}
+
+func newRemote(t *testing.T, dir string) *remote {
+	path := filepath.Join(dir, "remote")
+
+	repo, err := git2.PlainInit(path, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worktree, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &remote{t: t, Path: path, Repo: repo, Worktree: worktree}
+}
+
+func (r *remote) Url() string {
+	return "file://" + r.Path
+}
+
+// commit commits composer.json with the given description on the checked out
+// branch.
+func (r *remote) commit(description string) plumbing.Hash {
+	return r.commitAt(description, time.Now())
+}
+
+// commitAt commits like commit does, dated at the given time.
+func (r *remote) commitAt(description string, when time.Time) plumbing.Hash {
+	composerJson := `{"name": "org/package", "description": "` + description + `"}`
+
+	if err := ioutil.WriteFile(filepath.Join(r.Path, "composer.json"), []byte(composerJson), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+
+	if _, err := r.Worktree.Add("composer.json"); err != nil {
+		r.t.Fatal(err)
+	}
+
+	hash, err := r.Worktree.Commit(description, &git2.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: when},
+	})
+
+	if err != nil {
+		r.t.Fatal(err)
+	}
+
+	return hash
+}
+
+func (r *remote) setRef(name string, hash plumbing.Hash) {
+	if err := r.Repo.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(name), hash)); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+func (r *remote) removeRef(name string) {
+	if err := r.Repo.Storer.RemoveReference(plumbing.ReferenceName(name)); err != nil {
+		r.t.Fatal(err)
+	}
+}
+
+type fixture struct {
+	Remote *remote
+	Fake   *cloudsmithtest.Fake
+	Syncer *sync.Syncer
+	Repo   *config.Repository
+}
+
+// newFixture creates a remote with master and develop branches and a 1.0.0
+// tag, and a syncer publishing it to a fake registry.
+func newFixture(t *testing.T, buildMode string) (*fixture, func()) {
+	dir, err := ioutil.TempDir("", "cloudsmith-sync-sync")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := newRemote(t, dir)
+
+	tagged := origin.commit("tagged")
+	origin.setRef("refs/tags/1.0.0", tagged)
+
+	origin.setRef("refs/heads/develop", origin.commit("develop"))
+	origin.setRef("refs/heads/master", origin.commit("master"))
+
+	cfg := &config.Config{
+		DataDir:          filepath.Join(dir, "data"),
+		Owner:            "org",
+		TargetRepository: "repo",
+		BuildMode:        buildMode,
+		ReplaceStrategy:  config.ReplaceRepublish,
+		PollTimeout:      time.Second,
+	}
+
+	cfg.EnsureDirsExist()
+
+	store, err := state.Open(cfg.GetStatePath())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake := cloudsmithtest.NewFake()
+
+	syncer := sync.NewSyncer(cfg, fake, git.NewBackend(cfg))
+	syncer.State = store
+
+	return &fixture{
+		Remote: origin,
+		Fake:   fake,
+		Syncer: syncer,
+		Repo: &config.Repository{
+			Url:           origin.Url(),
+			ArchiveFormat: config.ArchiveZip,
+		},
+	}, func() { os.RemoveAll(dir) }
+}
+
+func statuses(results []report.Result) map[string]report.Status {
+	byRef := make(map[string]report.Status)
+
+	for _, result := range results {
+		if result.Error != nil {
+			byRef[result.Ref] = report.Status(result.Error.Error())
+			continue
+		}
+
+		byRef[result.Ref] = result.Status
+	}
+
+	return byRef
+}
+
+func TestSyncRepositoryPublishesBranchesAndTags(t *testing.T) {
+	for _, buildMode := range []string{config.BuildModeWorktree, config.BuildModeTree} {
+		f, cleanup := newFixture(t, buildMode)
+
+		results := statuses(f.Syncer.SyncRepository(f.Repo))
+
+		for _, ref := range []string{"master", "develop", "1.0.0"} {
+			if results[ref] != report.Published {
+				t.Errorf("[!] %s: %s was %s; want published", buildMode, ref, results[ref])
+			}
+		}
+
+		descriptions := map[string]string{
+			"dev-master":  "master",
+			"dev-develop": "develop",
+			"1.0.0":       "tagged",
+		}
+
+		if len(f.Fake.Uploads) != len(descriptions) {
+			t.Errorf("[!] %s: %d packages were uploaded; want %d", buildMode, len(f.Fake.Uploads), len(descriptions))
+		}
+
+		for _, upload := range f.Fake.Uploads {
+			version, _ := upload.ComposerJson["version"].(string)
+			description, _ := upload.ComposerJson["description"].(string)
+
+			if description != descriptions[version] {
+				t.Errorf("[!] %s: %s was built from %q; want %q", buildMode, version, description, descriptions[version])
+			}
+		}
+
+		cleanup()
+	}
+}
+
+func TestSyncRepositorySkipsUnchangedBranches(t *testing.T) {
+	f, cleanup := newFixture(t, config.BuildModeTree)
+	defer cleanup()
+
+	f.Syncer.SyncRepository(f.Repo)
+
+	results := statuses(f.Syncer.SyncRepository(f.Repo))
+
+	for _, ref := range []string{"master", "develop", "1.0.0"} {
+		if results[ref] != report.AlreadyExists {
+			t.Errorf("[!] unchanged %s was %s; want already-exists", ref, results[ref])
+		}
+	}
+
+	if err := f.Remote.Worktree.Checkout(&git2.CheckoutOptions{Branch: "refs/heads/master"}); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Remote.commit("moved")
+
+	results = statuses(f.Syncer.SyncRepository(f.Repo))
+
+	if results["master"] != report.Published {
+		t.Errorf("[!] moved master was %s; want published", results["master"])
+	}
+
+	upload := f.Fake.Uploads[len(f.Fake.Uploads)-1]
+
+	if upload.ComposerJson["description"] != "moved" || !upload.Republish {
+		t.Errorf("[!] moved master wasn't republished, got %+v", upload)
+	}
+}
+
+func TestSyncRepositoryReplacesBranchesByDeleting(t *testing.T) {
+	tests := []struct {
+		name    string
+		failure string
+		status  report.Status
+		err     error
+		deleted int
+	}{
+		{"completed", "", report.Published, nil, 1},
+		// Only completed packages are deleted, so the failed one is never gone
+		{"failed", "Invalid composer.json", report.Failed, sync.ErrPollTimeout, 0},
+	}
+
+	for _, test := range tests {
+		f, cleanup := newFixture(t, config.BuildModeTree)
+
+		f.Syncer.Target = sync.TargetBranches
+		f.Syncer.Config.ReplaceStrategy = config.ReplaceDelete
+		f.Syncer.Config.PollTimeout = time.Second
+
+		f.Fake.ProcessingFailure = test.failure
+		f.Syncer.Wait = true
+
+		f.Syncer.SyncRepository(f.Repo)
+
+		f.Fake.ProcessingFailure = ""
+		f.Syncer.Wait = false
+
+		if err := f.Remote.Worktree.Checkout(&git2.CheckoutOptions{Branch: "refs/heads/master"}); err != nil {
+			t.Fatal(err)
+		}
+
+		f.Remote.commit("moved")
+
+		var master report.Result
+
+		for _, result := range f.Syncer.SyncRepository(f.Repo) {
+			if result.Ref == "master" {
+				master = result
+			}
+		}
+
+		if master.Status != test.status || master.Error != test.err {
+			t.Errorf("[!] %s: master was %s with %v; want %s with %v", test.name, master.Status, master.Error, test.status, test.err)
+		}
+
+		if len(f.Fake.Deleted) != test.deleted {
+			t.Errorf("[!] %s: %d packages were deleted; want %d", test.name, len(f.Fake.Deleted), test.deleted)
+		}
+
+		if test.err == nil {
+			upload := f.Fake.Uploads[len(f.Fake.Uploads)-1]
+
+			if upload.ComposerJson["description"] != "moved" || upload.Republish {
+				t.Errorf("[!] %s: moved master was republished rather than uploaded again, got %+v", test.name, upload)
+			}
+		}
+
+		cleanup()
+	}
+}
+
+func TestSyncRepositoryFiltersRefs(t *testing.T) {
+	f, cleanup := newFixture(t, config.BuildModeTree)
+	defer cleanup()
+
+	f.Repo.Branches = config.RefFilter{Include: []string{"master"}}
+	f.Syncer.Target = sync.TargetBranches
+
+	results := statuses(f.Syncer.SyncRepository(f.Repo))
+
+	if results["master"] != report.Published {
+		t.Errorf("[!] master was %s; want published", results["master"])
+	}
+
+	if results["develop"] != report.Skipped {
+		t.Errorf("[!] develop was %s; want skipped", results["develop"])
+	}
+
+	if _, ok := results["1.0.0"]; ok {
+		t.Errorf("[!] a tag was synced while targeting branches")
+	}
+}
+
+func TestSyncRepositoryDryRun(t *testing.T) {
+	f, cleanup := newFixture(t, config.BuildModeTree)
+	defer cleanup()
+
+	f.Syncer.DryRun = true
+
+	results := statuses(f.Syncer.SyncRepository(f.Repo))
+
+	if results["master"] != report.WouldPublish {
+		t.Errorf("[!] master was %s; want would-publish", results["master"])
+	}
+
+	if len(f.Fake.Uploads) != 0 || len(f.Syncer.State.Packages()) != 0 {
+		t.Errorf("[!] a dry run uploaded or recorded packages")
+	}
+}
+
+func TestSyncRefRemovesDeletedRefs(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+		status report.Status
+	}{
+		{"delete", false, report.Deleted},
+		{"dry run", true, report.WouldDelete},
+	}
+
+	for _, test := range tests {
+		f, cleanup := newFixture(t, config.BuildModeTree)
+
+		f.Syncer.SyncRepository(f.Repo)
+
+		f.Remote.removeRef("refs/heads/develop")
+
+		f.Syncer.DryRun = test.dryRun
+
+		result := f.Syncer.SyncRef(f.Repo, "refs/heads/develop")
+
+		if result.Status != test.status || result.Version != "dev-develop" {
+			t.Errorf("[!] %s: SyncRef(develop) = %s %s; want dev-develop %s", test.name, result.Status, result.Version, test.status)
+		}
+
+		_, exists := f.Fake.Package("org/package", "dev-develop")
+		_, recorded := f.Syncer.State.Get("org/package", "dev-develop")
+
+		if exists != test.dryRun || recorded != test.dryRun {
+			t.Errorf("[!] %s: the package of a deleted branch exists: %v, recorded: %v; want %v", test.name, exists, recorded, test.dryRun)
+		}
+
+		cleanup()
+	}
+}
+
+func TestSyncRepositoryWaitsForProcessing(t *testing.T) {
+	tests := []struct {
+		name          string
+		checks        int
+		failure       string
+		timeout       time.Duration
+		status        report.Status
+		packageStatus string
+		statusChecks  int
+	}{
+		{"processed", 1, "", 5 * time.Second, report.Published, "Completed", 2},
+		{"failed", 0, "Invalid composer.json", 5 * time.Second, report.Failed, "Failed", 1},
+		{"timed out", 100, "", time.Second, report.Published, "Awaiting Sync", 1},
+		// Checks after 0, 1 and 3 seconds, the next one would be after the
+		// timeout
+		{"backs off", 100, "", 4 * time.Second, report.Published, "Awaiting Sync", 3},
+	}
+
+	for _, test := range tests {
+		f, cleanup := newFixture(t, config.BuildModeTree)
+
+		f.Fake.ProcessingChecks = test.checks
+		f.Fake.ProcessingFailure = test.failure
+		f.Syncer.Target = sync.TargetTags
+		f.Syncer.Wait = true
+		f.Syncer.Config.PollTimeout = test.timeout
+
+		results := f.Syncer.SyncRepository(f.Repo)
+
+		if len(results) != 1 {
+			t.Fatalf("[!] %s: got %d results; want 1", test.name, len(results))
+		}
+
+		result := results[0]
+
+		if result.Status != test.status || result.PackageStatus != test.packageStatus {
+			t.Errorf("[!] %s: result was %s with package status %q; want %s with %q", test.name, result.Status, result.PackageStatus, test.status, test.packageStatus)
+		}
+
+		if test.failure != "" && (result.Error == nil || !strings.Contains(result.Error.Error(), test.failure)) {
+			t.Errorf("[!] %s: result error was %v; want the failure reason", test.name, result.Error)
+		}
+
+		if f.Fake.StatusChecks != test.statusChecks {
+			t.Errorf("[!] %s: the package status was checked %d times; want %d", test.name, f.Fake.StatusChecks, test.statusChecks)
+		}
+
+		if _, recorded := f.Syncer.State.Get("org/package", "1.0.0"); recorded == (test.failure != "") {
+			t.Errorf("[!] %s: the package being recorded was %v", test.name, recorded)
+		}
+
+		cleanup()
+	}
+}
+
+func TestRetryFailedResyncsPackages(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  sync.RetryOptions
+		dryRun   bool
+		expected []string
+	}{
+		{"everything", sync.RetryOptions{}, false, []string{"org/package@1.0.0", "org/other@2.0.0"}},
+		{"by name", sync.RetryOptions{Names: []string{"org/pack*"}}, false, []string{"org/package@1.0.0"}},
+		{"by age", sync.RetryOptions{MaxAge: 24 * time.Hour}, false, []string{"org/package@1.0.0"}},
+		{"dry run", sync.RetryOptions{}, true, []string{"org/package@1.0.0", "org/other@2.0.0"}},
+	}
+
+	for _, test := range tests {
+		f, cleanup := newFixture(t, config.BuildModeTree)
+
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "dev-master"})
+		f.Fake.Add(cloudsmith_api.ModelPackage{
+			Name:         "org/package",
+			Version:      "1.0.0",
+			StatusStr:    "Failed",
+			StatusReason: "Invalid archive",
+			UploadedAt:   time.Now().Add(-time.Hour).Format(time.RFC3339),
+		})
+		f.Fake.Add(cloudsmith_api.ModelPackage{
+			Name:       "org/other",
+			Version:    "2.0.0",
+			StatusStr:  "Failed",
+			UploadedAt: time.Now().Add(-48 * time.Hour).Format(time.RFC3339),
+		})
+
+		f.Syncer.DryRun = test.dryRun
+
+		results, err := f.Syncer.RetryFailed(test.options)
+
+		if err != nil {
+			t.Fatalf("[!] %s: RetryFailed() returned %v", test.name, err)
+		}
+
+		var retried []string
+
+		status := report.Resynced
+
+		if test.dryRun {
+			status = report.WouldResync
+		}
+
+		for _, result := range results {
+			if result.Status != status {
+				t.Errorf("[!] %s: %s@%s was %s; want %s", test.name, result.Package, result.Version, result.Status, status)
+			}
+
+			retried = append(retried, result.Package+"@"+result.Version)
+		}
+
+		if strings.Join(retried, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("[!] %s: RetryFailed() retried %v; want %v", test.name, retried, test.expected)
+		}
+
+		if resynced := len(f.Fake.Resynced); (resynced == 0) != test.dryRun {
+			t.Errorf("[!] %s: %d packages were resynced on Cloudsmith", test.name, resynced)
+		}
+
+		cleanup()
+	}
+}
+
+func TestRetryFailedRebuildsPackages(t *testing.T) {
+	f, cleanup := newFixture(t, config.BuildModeTree)
+	defer cleanup()
+
+	f.Syncer.Config.Repositories = []config.Repository{*f.Repo}
+
+	f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "1.0.0", StatusStr: "Failed"})
+	f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/unknown", Version: "1.0.0", StatusStr: "Failed"})
+
+	results, err := f.Syncer.RetryFailed(sync.RetryOptions{Rebuild: true})
+
+	if err != nil {
+		t.Fatalf("[!] RetryFailed() returned %v", err)
+	}
+
+	byPackage := make(map[string]report.Result)
+
+	for _, result := range results {
+		byPackage[result.Package] = result
+	}
+
+	if rebuilt := byPackage["org/package"]; rebuilt.Status != report.Published || rebuilt.Ref != "1.0.0" {
+		t.Errorf("[!] org/package was %s from %q; want published from 1.0.0", rebuilt.Status, rebuilt.Ref)
+	}
+
+	if byPackage["org/unknown"].Status != report.Failed {
+		t.Errorf("[!] a package without a ref was %s; want failed", byPackage["org/unknown"].Status)
+	}
+
+	pkg, ok := f.Fake.Package("org/package", "1.0.0")
+
+	if !ok || pkg.StatusStr != "Completed" || len(f.Fake.Uploads) != 1 {
+		t.Errorf("[!] the failed package wasn't replaced, got %+v", pkg)
+	}
+}
+
+func TestRetryFailedDeletesFailedPackagesBeforeRebuilding(t *testing.T) {
+	f, cleanup := newFixture(t, config.BuildModeTree)
+	defer cleanup()
+
+	f.Syncer.Config.Repositories = []config.Repository{*f.Repo}
+	f.Syncer.Config.ReplaceStrategy = config.ReplaceDelete
+
+	failed := f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "1.0.0", StatusStr: "Failed"})
+
+	results, err := f.Syncer.RetryFailed(sync.RetryOptions{Rebuild: true})
+
+	if err != nil || len(results) != 1 || results[0].Status != report.Published {
+		t.Fatalf("[!] RetryFailed() = %+v, %v; want org/package published", results, err)
+	}
+
+	if len(f.Fake.Deleted) != 1 || f.Fake.Deleted[0].SlugPerm != failed.SlugPerm {
+		t.Errorf("[!] deleted %+v; want only the failed package", f.Fake.Deleted)
+	}
+
+	if len(f.Fake.Uploads) != 1 || f.Fake.Uploads[0].Republish {
+		t.Errorf("[!] uploaded %+v; want a single upload that doesn't republish", f.Fake.Uploads)
+	}
+
+	if pkg, ok := f.Fake.Package("org/package", "1.0.0"); !ok || pkg.StatusStr != "Completed" {
+		t.Errorf("[!] the failed package wasn't replaced, got %+v", pkg)
+	}
+}
+
+func TestRetryFailedKeepsPackagesThatCantBeRebuilt(t *testing.T) {
+	f, cleanup := newFixture(t, config.BuildModeTree)
+	defer cleanup()
+
+	f.Repo.Tags = config.RefFilter{Exclude: []string{"1.0.0"}}
+	f.Syncer.Config.Repositories = []config.Repository{*f.Repo}
+
+	f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "1.0.0", StatusStr: "Failed"})
+
+	results, err := f.Syncer.RetryFailed(sync.RetryOptions{Rebuild: true})
+
+	if err != nil || len(results) != 1 || results[0].Status != report.Skipped {
+		t.Fatalf("[!] RetryFailed() = %+v, %v; want the filtered ref skipped", results, err)
+	}
+
+	pkg, ok := f.Fake.Package("org/package", "1.0.0")
+
+	if !ok || pkg.StatusStr != "Failed" || len(f.Fake.Deleted) != 0 {
+		t.Errorf("[!] the failed package was removed without a replacement, got %+v", pkg)
+	}
+}
+
+func TestPruneRepositories(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     bool
+		dryRun   bool
+		expected map[string]report.Status
+	}{
+		{"branches", false, false, map[string]report.Status{
+			"dev-develop": report.Deleted,
+			"dev-gone":    report.Deleted,
+			"dev-legacy":  report.Skipped,
+			"0.9.0":       report.Skipped,
+		}},
+		{"tags", true, false, map[string]report.Status{
+			"dev-develop": report.Deleted,
+			"dev-gone":    report.Deleted,
+			"dev-legacy":  report.Skipped,
+			"0.9.0":       report.Deleted,
+		}},
+		{"dry run", false, true, map[string]report.Status{
+			"dev-develop": report.WouldDelete,
+			"dev-gone":    report.WouldDelete,
+			"dev-legacy":  report.Skipped,
+			"0.9.0":       report.Skipped,
+		}},
+	}
+
+	for _, test := range tests {
+		f, cleanup := newFixture(t, config.BuildModeTree)
+
+		f.Syncer.SyncRepository(f.Repo)
+		f.Remote.removeRef("refs/heads/develop")
+
+		for _, version := range []string{"dev-gone", "dev-legacy", "0.9.0"} {
+			f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: version})
+		}
+
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package-other", Version: "dev-gone"})
+
+		f.Syncer.Config.Prune = config.PruneRules{Keep: []string{"dev-legacy"}, Tags: test.tags}
+		f.Syncer.DryRun = test.dryRun
+
+		results := f.Syncer.PruneRepositories([]config.Repository{*f.Repo})
+
+		actual := make(map[string]report.Status)
+
+		for _, result := range results {
+			actual[result.Version] = result.Status
+		}
+
+		if len(actual) != len(test.expected) {
+			t.Errorf("[!] %s: pruning looked at %v; want %v", test.name, actual, test.expected)
+		}
+
+		for version, status := range test.expected {
+			if actual[version] != status {
+				t.Errorf("[!] %s: %s was %s; want %s", test.name, version, actual[version], status)
+			}
+
+			_, exists := f.Fake.Package("org/package", version)
+
+			if deleted := status == report.Deleted; exists == deleted {
+				t.Errorf("[!] %s: %s exists is %v", test.name, version, exists)
+			}
+		}
+
+		for _, version := range []string{"dev-master", "1.0.0"} {
+			if _, ok := f.Fake.Package("org/package", version); !ok {
+				t.Errorf("[!] %s: the package of an existing ref, %s, was pruned", test.name, version)
+			}
+		}
+
+		if _, ok := f.Fake.Package("org/package-other", "dev-gone"); !ok {
+			t.Errorf("[!] %s: a package of another name was pruned", test.name)
+		}
+
+		cleanup()
+	}
+}
+
+func TestPruneRepositoriesKeepsPackagesOfUnreadableRefs(t *testing.T) {
+	f, cleanup := newFixture(t, config.BuildModeTree)
+	defer cleanup()
+
+	f.Syncer.SyncRepository(f.Repo)
+
+	master, err := f.Remote.Repo.Reference("refs/heads/master", true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A branch whose composer.json has since been broken
+	if err := ioutil.WriteFile(filepath.Join(f.Remote.Path, "composer.json"), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.Remote.Worktree.Add("composer.json"); err != nil {
+		t.Fatal(err)
+	}
+
+	broken, err := f.Remote.Worktree.Commit("broken", &git2.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f.Remote.setRef("refs/heads/broken", broken)
+	f.Remote.setRef("refs/heads/master", master.Hash())
+
+	f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "dev-broken"})
+
+	results := f.Syncer.PruneRepositories([]config.Repository{*f.Repo})
+
+	if len(results) != 1 || results[0].Ref != "broken" || results[0].Status != report.Failed {
+		t.Errorf("[!] PruneRepositories() = %+v; want only broken to fail", results)
+	}
+
+	if _, ok := f.Fake.Package("org/package", "dev-broken"); !ok {
+		t.Errorf("[!] the package of a branch that still exists was pruned")
+	}
+}
+
+func TestApplyRetention(t *testing.T) {
+	tests := []struct {
+		name   string
+		dryRun bool
+		status report.Status
+	}{
+		{"delete", false, report.Deleted},
+		{"dry run", true, report.WouldDelete},
+	}
+
+	expected := map[string]string{
+		"dev-feature": "branch hasn't been updated in 7 days",
+		"dev-gone":    "branch hasn't been updated in 7 days",
+		"1.1.0-beta1": "only the newest 1 pre-releases of 1.1 are kept",
+		"1.1.0-beta2": "only the newest 1 pre-releases of 1.1 are kept",
+	}
+
+	kept := []string{"dev-master", "dev-develop", "dev-legacy", "dev-fresh", "dev-release/old", "2.x-dev", "1.0.0", "1.1.0-RC1"}
+
+	for _, test := range tests {
+		f, cleanup := newFixture(t, config.BuildModeTree)
+
+		monthAgo := time.Now().AddDate(0, 0, -30)
+
+		f.Remote.setRef("refs/heads/feature", f.Remote.commitAt("feature", monthAgo))
+		f.Remote.setRef("refs/heads/legacy", f.Remote.commitAt("legacy", monthAgo))
+		f.Remote.setRef("refs/tags/1.1.0-beta1", f.Remote.commit("beta1"))
+		f.Remote.setRef("refs/tags/1.1.0-beta2", f.Remote.commit("beta2"))
+
+		f.Repo.Retention = &config.RetentionRules{
+			BranchDays:          7,
+			PreReleasesPerMinor: 1,
+			KeepBranches:        []string{"legacy", "/^release\\//", "2.x"},
+		}
+
+		syncStatuses := statuses(f.Syncer.SyncRepository(f.Repo))
+
+		for ref, status := range map[string]report.Status{
+			"feature":     report.Skipped,
+			"legacy":      report.Published,
+			"1.1.0-beta1": report.Skipped,
+			"1.1.0-beta2": report.Published,
+		} {
+			if syncStatuses[ref] != status {
+				t.Errorf("[!] %s: syncing %s was %s; want %s", test.name, ref, syncStatuses[ref], status)
+			}
+		}
+
+		// Packages published before the rules were configured, and of refs
+		// that are gone
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "dev-feature"})
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "dev-gone", UploadedAt: monthAgo.Format(time.RFC3339)})
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "dev-fresh", UploadedAt: time.Now().Format(time.RFC3339)})
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "dev-release/old", UploadedAt: monthAgo.Format(time.RFC3339)})
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "2.x-dev", UploadedAt: monthAgo.Format(time.RFC3339)})
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "1.1.0-beta1"})
+		f.Fake.Add(cloudsmith_api.ModelPackage{Name: "org/package", Version: "1.1.0-RC1"})
+
+		f.Syncer.DryRun = test.dryRun
+
+		results := f.Syncer.ApplyRetention([]config.Repository{*f.Repo})
+
+		actual := make(map[string]report.Result)
+
+		for _, result := range results {
+			actual[result.Version] = result
+		}
+
+		if len(actual) != len(expected) {
+			t.Errorf("[!] %s: retention looked at %v; want %v", test.name, actual, expected)
+		}
+
+		for version, reason := range expected {
+			if result := actual[version]; result.Status != test.status || result.Reason != reason {
+				t.Errorf("[!] %s: %s was %s (%s); want %s (%s)", test.name, version, result.Status, result.Reason, test.status, reason)
+			}
+
+			if _, exists := f.Fake.Package("org/package", version); exists != test.dryRun {
+				t.Errorf("[!] %s: %s exists is %v", test.name, version, exists)
+			}
+		}
+
+		for _, version := range kept {
+			if _, ok := f.Fake.Package("org/package", version); !ok {
+				t.Errorf("[!] %s: %s wasn't kept", test.name, version)
+			}
+		}
+
+		cleanup()
+	}
+}
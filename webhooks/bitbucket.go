@@ -0,0 +1,96 @@
+package webhooks
+
+import (
+	"fmt"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"gopkg.in/go-playground/webhooks.v5/bitbucket-server"
+	"net/http"
+)
+
+var BitbucketHook *bitbucketserver.Webhook
+
+func HandleBitbucketWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := BitbucketHook.Parse(r, bitbucketserver.RepositoryReferenceChangedEvent, bitbucketserver.DiagnosticsPingEvent)
+	if err != nil {
+		if err == bitbucketserver.ErrMissingEventKeyHeader || err == bitbucketserver.ErrMissingHubSignatureHeader {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if err == bitbucketserver.ErrHMACVerificationFailed {
+			w.WriteHeader(403)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if err == bitbucketserver.ErrEventNotFound {
+			w.WriteHeader(422)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	switch payload.(type) {
+	case bitbucketserver.DiagnosticsPingPayload:
+		w.WriteHeader(200)
+		w.Write([]byte("pong"))
+
+	case bitbucketserver.RepositoryReferenceChangedPayload:
+		push := payload.(bitbucketserver.RepositoryReferenceChangedPayload)
+		repoCfg, err := findBitbucketRepository(push.Repository)
+
+		if err != nil {
+			w.WriteHeader(422)
+			w.Write([]byte("repository not configured"))
+			return
+		}
+
+		// A single push can change several branches and tags at once
+		queued := 0
+
+		for _, change := range push.Changes {
+			added, err := queueRef(repoCfg.Url, change.ReferenceID)
+
+			if err != nil {
+				w.WriteHeader(500)
+				w.Write([]byte(err.Error()))
+				return
+			}
+
+			if added {
+				queued++
+			}
+		}
+
+		w.WriteHeader(202)
+		w.Write([]byte(fmt.Sprintf("queued %d of %d refs", queued, len(push.Changes))))
+	}
+}
+
+// findBitbucketRepository matches the repository of a Bitbucket Server event
+// against the config, using its clone links when they're sent and falling back
+// to the project key and repository slug.
+func findBitbucketRepository(repository bitbucketserver.Repository) (config.Repository, error) {
+	var urls []string
+
+	if clones, ok := repository.Links["clone"].([]interface{}); ok {
+		for _, clone := range clones {
+			if link, ok := clone.(map[string]interface{}); ok {
+				if href, ok := link["href"].(string); ok {
+					urls = append(urls, href)
+				}
+			}
+		}
+	}
+
+	if repoCfg, err := Config.FindRepository(urls...); err == nil {
+		return repoCfg, nil
+	}
+
+	return Config.FindRepositoryByPath(repository.Project.Key + "/" + repository.Slug)
+}
@@ -0,0 +1,116 @@
+package webhooks_test
+
+import (
+	"bytes"
+	"github.com/Lavoaster/cloudsmith-sync/webhooks"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var bitbucketWebhookTests = []struct {
+	name      string
+	event     string
+	signature string
+	body      string
+	expected  int
+}{
+	{
+		"ping",
+		"diagnostics:ping",
+		"",
+		``,
+		200,
+	},
+	{
+		"refs changed",
+		"repo:refs_changed",
+		"valid",
+		`{
+			"eventKey": "repo:refs_changed",
+			"repository": {
+				"slug": "repo",
+				"project": {"key": "PROJ"},
+				"links": {"clone": [{"href": "ssh://git@bitbucket.example.com:7999/proj/repo.git", "name": "ssh"}]}
+			},
+			"changes": [
+				{"refId": "refs/heads/master", "type": "UPDATE"},
+				{"refId": "refs/tags/1.0.0", "type": "ADD"}
+			]
+		}`,
+		202,
+	},
+	{
+		"without clone links",
+		"repo:refs_changed",
+		"valid",
+		`{"repository": {"slug": "repo", "project": {"key": "PROJ"}}, "changes": [{"refId": "refs/heads/develop"}]}`,
+		202,
+	},
+	{
+		"invalid signature",
+		"repo:refs_changed",
+		"sha256=00",
+		`{"repository": {"slug": "repo", "project": {"key": "PROJ"}}, "changes": [{"refId": "refs/heads/feature"}]}`,
+		403,
+	},
+	{
+		"missing signature",
+		"repo:refs_changed",
+		"",
+		`{"repository": {"slug": "repo", "project": {"key": "PROJ"}}, "changes": [{"refId": "refs/heads/feature"}]}`,
+		400,
+	},
+	{
+		"missing event",
+		"",
+		"valid",
+		`{"repository": {"slug": "repo", "project": {"key": "PROJ"}}, "changes": [{"refId": "refs/heads/feature"}]}`,
+		400,
+	},
+	{
+		"unknown repository",
+		"repo:refs_changed",
+		"valid",
+		`{"repository": {"slug": "unknown", "project": {"key": "PROJ"}}, "changes": [{"refId": "refs/heads/feature"}]}`,
+		422,
+	},
+}
+
+func TestHandleBitbucketWebhook(t *testing.T) {
+	queuePath, teardown := setupWebhooks(t)
+	defer teardown()
+
+	for _, test := range bitbucketWebhookTests {
+		body := []byte(test.body)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/bitbucket", bytes.NewReader(body))
+
+		if test.event != "" {
+			req.Header.Set("X-Event-Key", test.event)
+		}
+
+		if test.signature == "valid" {
+			req.Header.Set("X-Hub-Signature", sign(body, "bitbucket-secret"))
+		} else if test.signature != "" {
+			req.Header.Set("X-Hub-Signature", test.signature)
+		}
+
+		rec := httptest.NewRecorder()
+		webhooks.HandleBitbucketWebhook(rec, req)
+
+		if rec.Code != test.expected {
+			t.Errorf("[!] %s: status = %d (%s); want %d", test.name, rec.Code, rec.Body.String(), test.expected)
+		}
+	}
+
+	expected := []string{
+		"ssh://git@bitbucket.example.com:7999/proj/repo.git refs/heads/master",
+		"ssh://git@bitbucket.example.com:7999/proj/repo.git refs/tags/1.0.0",
+		"ssh://git@bitbucket.example.com:7999/proj/repo.git refs/heads/develop",
+	}
+
+	if jobs := queuedJobs(t, queuePath); !reflect.DeepEqual(jobs, expected) {
+		t.Errorf("[!] queued %v; want %v", jobs, expected)
+	}
+}
@@ -0,0 +1,87 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxGenericPayloadSize caps how much of a request body is read, as it's
+// read before the request could be authenticated.
+const maxGenericPayloadSize = 1 << 20
+
+// GenericPayload asks for a ref of a repository to be synced, so that any CI
+// system or script can trigger a sync.
+type GenericPayload struct {
+	Repository string `json:"repository"`
+	Ref        string `json:"ref"`
+	Commit     string `json:"commit,omitempty"`
+}
+
+// HandleGenericWebhook accepts requests signed with an HMAC SHA256 of the body
+// using the webhook secret (X-Hub-Signature-256: sha256=<hex>), or carrying the
+// webhook token as a bearer token.
+func HandleGenericWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxGenericPayloadSize))
+
+	if err != nil {
+		w.WriteHeader(413)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if !isAuthorized(r, body) {
+		w.WriteHeader(403)
+		w.Write([]byte("signature or token verification failed"))
+		return
+	}
+
+	var payload GenericPayload
+
+	if err := json.Unmarshal(body, &payload); err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte("invalid payload: " + err.Error()))
+		return
+	}
+
+	if payload.Repository == "" || payload.Ref == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("repository and ref are required"))
+		return
+	}
+
+	repoCfg, err := Config.FindRepository(payload.Repository)
+
+	if err != nil {
+		w.WriteHeader(422)
+		w.Write([]byte("repository not configured"))
+		return
+	}
+
+	enqueueCommit(w, repoCfg.Url, payload.Ref, payload.Commit)
+}
+
+func isAuthorized(r *http.Request, body []byte) bool {
+	if signature := r.Header.Get("X-Hub-Signature-256"); signature != "" && Config.WebhookSecret != "" {
+		mac := hmac.New(sha256.New, []byte(Config.WebhookSecret))
+		mac.Write(body)
+		expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+		return hmac.Equal([]byte(signature), []byte(expected))
+	}
+
+	authorization := r.Header.Get("Authorization")
+
+	if strings.HasPrefix(authorization, "Bearer ") && Config.WebhookToken != "" {
+		token := strings.TrimPrefix(authorization, "Bearer ")
+
+		return subtle.ConstantTimeCompare([]byte(token), []byte(Config.WebhookToken)) == 1
+	}
+
+	return false
+}
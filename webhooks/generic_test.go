@@ -0,0 +1,188 @@
+package webhooks_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/queue"
+	"github.com/Lavoaster/cloudsmith-sync/webhooks"
+	"gopkg.in/go-playground/webhooks.v5/bitbucket-server"
+	"gopkg.in/go-playground/webhooks.v5/gitlab"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setupWebhooks configures the webhooks with a queue that's never started, so
+// the jobs queued can be read back with queuedJobs.
+func setupWebhooks(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "webhooks")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks.Config = &config.Config{
+		WebhookSecret:          "secret",
+		WebhookToken:           "token",
+		GitlabWebhookSecret:    "gitlab-token",
+		BitbucketWebhookSecret: "bitbucket-secret",
+		Repositories: []config.Repository{
+			{Url: "git@github.com:org/repo.git"},
+			{Url: "git@gitlab.com:group/project.git"},
+			{Url: "ssh://git@bitbucket.example.com:7999/proj/repo.git"},
+		},
+	}
+
+	webhooks.GitlabHook, err = gitlab.New(gitlab.Options.Secret("gitlab-token"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks.BitbucketHook, err = bitbucketserver.New(bitbucketserver.Options.Secret("bitbucket-secret"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queuePath := filepath.Join(dir, "queue.json")
+
+	webhooks.Queue, err = queue.New(queuePath, func(job queue.Job) {})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return queuePath, func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// queuedJobs returns the repository and ref of every job persisted at path.
+func queuedJobs(t *testing.T, path string) []string {
+	raw, err := ioutil.ReadFile(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var persisted struct {
+		Jobs []queue.Job `json:"jobs"`
+	}
+
+	if err := json.Unmarshal(raw, &persisted); err != nil {
+		t.Fatal(err)
+	}
+
+	var jobs []string
+
+	for _, job := range persisted.Jobs {
+		jobs = append(jobs, job.Repository+" "+job.Ref)
+	}
+
+	return jobs
+}
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+var genericWebhookTests = []struct {
+	name     string
+	body     string
+	headers  map[string]string
+	expected int
+}{
+	{
+		"valid signature",
+		`{"repository": "https://github.com/org/repo.git", "ref": "refs/heads/master"}`,
+		map[string]string{"X-Hub-Signature-256": "valid"},
+		202,
+	},
+	{
+		"valid token",
+		`{"repository": "git@github.com:org/repo.git", "ref": "1.0.0", "commit": "abc123"}`,
+		map[string]string{"Authorization": "Bearer token"},
+		202,
+	},
+	{
+		"invalid signature",
+		`{"repository": "git@github.com:org/repo.git", "ref": "refs/heads/master"}`,
+		map[string]string{"X-Hub-Signature-256": "sha256=00"},
+		403,
+	},
+	{
+		"invalid token",
+		`{"repository": "git@github.com:org/repo.git", "ref": "refs/heads/master"}`,
+		map[string]string{"Authorization": "Bearer nope"},
+		403,
+	},
+	{
+		"unauthenticated",
+		`{"repository": "git@github.com:org/repo.git", "ref": "refs/heads/master"}`,
+		map[string]string{},
+		403,
+	},
+	{
+		"missing ref",
+		`{"repository": "git@github.com:org/repo.git"}`,
+		map[string]string{"Authorization": "Bearer token"},
+		400,
+	},
+	{
+		"unknown repository",
+		`{"repository": "git@github.com:org/unknown.git", "ref": "refs/heads/master"}`,
+		map[string]string{"Authorization": "Bearer token"},
+		422,
+	},
+	{
+		"payload too large",
+		`{"repository": "git@github.com:org/repo.git", "ref": "` + strings.Repeat("a", 1<<20) + `"}`,
+		map[string]string{"Authorization": "Bearer token"},
+		413,
+	},
+}
+
+func TestHandleGenericWebhook(t *testing.T) {
+	_, teardown := setupWebhooks(t)
+	defer teardown()
+
+	for _, test := range genericWebhookTests {
+		body := []byte(test.body)
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/generic", bytes.NewReader(body))
+
+		for key, value := range test.headers {
+			if value == "valid" {
+				value = sign(body, "secret")
+			}
+
+			req.Header.Set(key, value)
+		}
+
+		rec := httptest.NewRecorder()
+		webhooks.HandleGenericWebhook(rec, req)
+
+		if rec.Code != test.expected {
+			t.Errorf("[!] %s: status = %d (%s); want %d", test.name, rec.Code, rec.Body.String(), test.expected)
+		}
+	}
+
+	if webhooks.Queue.Len() != 2 {
+		t.Errorf("[!] Queue.Len() = %d; want 2", webhooks.Queue.Len())
+	}
+}
@@ -0,0 +1,60 @@
+package webhooks
+
+import (
+	"gopkg.in/go-playground/webhooks.v5/gitlab"
+	"net/http"
+)
+
+var GitlabHook *gitlab.Webhook
+
+func HandleGitlabWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := GitlabHook.Parse(r, gitlab.PushEvents, gitlab.TagEvents)
+	if err != nil {
+		if err == gitlab.ErrMissingGitLabEventHeader {
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if err == gitlab.ErrGitLabTokenVerificationFailed {
+			w.WriteHeader(403)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if err == gitlab.ErrEventNotFound {
+			w.WriteHeader(422)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	var ref string
+	var project gitlab.Project
+
+	switch payload.(type) {
+	case gitlab.PushEventPayload:
+		push := payload.(gitlab.PushEventPayload)
+		ref = push.Ref
+		project = push.Project
+
+	case gitlab.TagEventPayload:
+		push := payload.(gitlab.TagEventPayload)
+		ref = push.Ref
+		project = push.Project
+	}
+
+	repoCfg, err := Config.FindRepository(project.GitSSHURL, project.GitHTTPURL)
+
+	if err != nil {
+		w.WriteHeader(422)
+		w.Write([]byte("repository not configured"))
+		return
+	}
+
+	enqueue(w, repoCfg.Url, ref)
+}
@@ -0,0 +1,92 @@
+package webhooks_test
+
+import (
+	"bytes"
+	"github.com/Lavoaster/cloudsmith-sync/webhooks"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+var gitlabWebhookTests = []struct {
+	name     string
+	event    string
+	token    string
+	body     string
+	expected int
+}{
+	{
+		"push",
+		"Push Hook",
+		"gitlab-token",
+		`{"object_kind": "push", "ref": "refs/heads/master", "project": {"git_ssh_url": "git@gitlab.com:group/project.git", "git_http_url": "https://gitlab.com/group/project.git"}}`,
+		202,
+	},
+	{
+		"tag push",
+		"Tag Push Hook",
+		"gitlab-token",
+		`{"object_kind": "tag_push", "ref": "refs/tags/1.0.0", "project": {"git_http_url": "https://gitlab.com/group/project.git"}}`,
+		202,
+	},
+	{
+		"invalid token",
+		"Push Hook",
+		"nope",
+		`{"object_kind": "push", "ref": "refs/heads/develop", "project": {"git_ssh_url": "git@gitlab.com:group/project.git"}}`,
+		403,
+	},
+	{
+		"missing event",
+		"",
+		"gitlab-token",
+		`{"object_kind": "push", "ref": "refs/heads/develop", "project": {"git_ssh_url": "git@gitlab.com:group/project.git"}}`,
+		400,
+	},
+	{
+		"unhandled event",
+		"Issue Hook",
+		"gitlab-token",
+		`{"object_kind": "issue", "project": {"git_ssh_url": "git@gitlab.com:group/project.git"}}`,
+		422,
+	},
+	{
+		"unknown repository",
+		"Push Hook",
+		"gitlab-token",
+		`{"object_kind": "push", "ref": "refs/heads/develop", "project": {"git_ssh_url": "git@gitlab.com:group/unknown.git"}}`,
+		422,
+	},
+}
+
+func TestHandleGitlabWebhook(t *testing.T) {
+	queuePath, teardown := setupWebhooks(t)
+	defer teardown()
+
+	for _, test := range gitlabWebhookTests {
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/gitlab", bytes.NewReader([]byte(test.body)))
+
+		if test.event != "" {
+			req.Header.Set("X-Gitlab-Event", test.event)
+		}
+
+		req.Header.Set("X-Gitlab-Token", test.token)
+
+		rec := httptest.NewRecorder()
+		webhooks.HandleGitlabWebhook(rec, req)
+
+		if rec.Code != test.expected {
+			t.Errorf("[!] %s: status = %d (%s); want %d", test.name, rec.Code, rec.Body.String(), test.expected)
+		}
+	}
+
+	expected := []string{
+		"git@gitlab.com:group/project.git refs/heads/master",
+		"git@gitlab.com:group/project.git refs/tags/1.0.0",
+	}
+
+	if jobs := queuedJobs(t, queuePath); !reflect.DeepEqual(jobs, expected) {
+		t.Errorf("[!] queued %v; want %v", jobs, expected)
+	}
+}
@@ -0,0 +1,98 @@
+package webhooks
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/queue"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/sync"
+	"net/http"
+	"strings"
+)
+
+var Syncer *sync.Syncer
+var Config *config.Config
+var Queue *queue.Queue
+
+// Logf receives the outcome of jobs processed in the background.
+var Logf = func(format string, a ...interface{}) {}
+
+// ProcessJob syncs the ref of a queued job, it's the handler of the queue.
+func ProcessJob(job queue.Job) {
+	result, err := RunJob(job)
+
+	if err != nil {
+		Logf("Dropping job for %s %s - %v", job.Repository, job.Ref, err)
+		return
+	}
+
+	if result.Status == report.Failed {
+		Logf("Job for %s %s failed - %s", job.Repository, job.Ref, result.Detail())
+		return
+	}
+
+	Logf("Job for %s %s finished - %s %s", job.Repository, job.Ref, result.Status, result.Detail())
+}
+
+// RunJob syncs the ref of a job, returning an error when its repository isn't
+// configured. The ref may have moved on since the job was queued, in which
+// case its latest commit is synced and the reason of the result says so.
+func RunJob(job queue.Job) (report.Result, error) {
+	repoCfg, err := Config.GetRepository(job.Repository)
+
+	if err != nil {
+		return report.Result{}, err
+	}
+
+	result := Syncer.SyncRef(&repoCfg, job.Ref)
+
+	if job.Commit != "" && result.Commit != "" && !strings.HasPrefix(result.Commit, job.Commit) {
+		mismatch := "synced " + result.Commit + " rather than " + job.Commit + " as the ref has moved on"
+
+		if result.Reason != "" {
+			mismatch = result.Reason + ", " + mismatch
+		}
+
+		result.Reason = mismatch
+	}
+
+	return result, nil
+}
+
+// enqueue queues a ref to be synced and responds straight away, as syncing
+// can take longer than webhook senders are willing to wait.
+func enqueue(w http.ResponseWriter, repositoryUrl, ref string) {
+	enqueueCommit(w, repositoryUrl, ref, "")
+}
+
+// enqueueCommit works like enqueue, recording the commit the sender expects
+// the ref to be at.
+func enqueueCommit(w http.ResponseWriter, repositoryUrl, ref, commit string) {
+	added, err := Queue.Enqueue(queue.Job{
+		Repository: repositoryUrl,
+		Ref:        ref,
+		Commit:     commit,
+	})
+
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	w.WriteHeader(202)
+
+	if added {
+		w.Write([]byte("queued"))
+	} else {
+		w.Write([]byte("already queued"))
+	}
+}
+
+// queueRef queues a ref to be synced, reporting whether it wasn't queued
+// already.
+func queueRef(repositoryUrl, ref string) (bool, error) {
+	return Queue.Enqueue(queue.Job{
+		Repository: repositoryUrl,
+		Ref:        ref,
+	})
+}
@@ -0,0 +1,108 @@
+package webhooks_test
+
+import (
+	"github.com/Lavoaster/cloudsmith-sync/cloudsmith/cloudsmithtest"
+	"github.com/Lavoaster/cloudsmith-sync/config"
+	"github.com/Lavoaster/cloudsmith-sync/git"
+	"github.com/Lavoaster/cloudsmith-sync/queue"
+	"github.com/Lavoaster/cloudsmith-sync/report"
+	"github.com/Lavoaster/cloudsmith-sync/sync"
+	"github.com/Lavoaster/cloudsmith-sync/webhooks"
+	git2 "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupJobs configures the webhooks to sync a local repository, whose master
+// has moved on from the commit returned, to a fake registry.
+func setupJobs(t *testing.T) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "webhooks-jobs")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remotePath := filepath.Join(dir, "remote")
+
+	repo, err := git2.PlainInit(remotePath, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worktree, err := repo.Worktree()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit := func(description string) string {
+		composerJson := `{"name": "org/package", "description": "` + description + `"}`
+
+		if err := ioutil.WriteFile(filepath.Join(remotePath, "composer.json"), []byte(composerJson), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := worktree.Add("composer.json"); err != nil {
+			t.Fatal(err)
+		}
+
+		hash, err := worktree.Commit(description, &git2.CommitOptions{
+			Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+		})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return hash.String()
+	}
+
+	queued := commit("queued")
+	commit("moved")
+
+	url := "file://" + remotePath
+
+	cfg := &config.Config{
+		DataDir:          filepath.Join(dir, "data"),
+		Owner:            "org",
+		TargetRepository: "repo",
+		BuildMode:        config.BuildModeTree,
+		ReplaceStrategy:  config.ReplaceRepublish,
+		PollTimeout:      time.Second,
+		Repositories: []config.Repository{
+			{Url: url, ArchiveFormat: config.ArchiveZip},
+		},
+	}
+
+	cfg.EnsureDirsExist()
+
+	webhooks.Config = cfg
+	webhooks.Syncer = sync.NewSyncer(cfg, cloudsmithtest.NewFake(), git.NewBackend(cfg))
+
+	return url, queued, func() { os.RemoveAll(dir) }
+}
+
+func TestRunJobReportsMovedRefs(t *testing.T) {
+	url, queued, teardown := setupJobs(t)
+	defer teardown()
+
+	result, err := webhooks.RunJob(queue.Job{Repository: url, Ref: "refs/heads/master", Commit: queued[:7]})
+
+	if err != nil || result.Status != report.Published {
+		t.Fatalf("[!] RunJob() = %+v, %v; want master published", result, err)
+	}
+
+	if result.Commit == queued || !strings.Contains(result.Reason, "rather than "+queued[:7]) {
+		t.Errorf("[!] RunJob() synced %s with reason %q; want the moved on commit reported", result.Commit, result.Reason)
+	}
+
+	if _, err := webhooks.RunJob(queue.Job{Repository: "git@github.com:org/unknown.git", Ref: "master"}); err == nil {
+		t.Errorf("[!] RunJob() of an unconfigured repository didn't fail")
+	}
+}